@@ -4,6 +4,8 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 var (
@@ -86,6 +88,7 @@ type ResponseChannel struct {
 	once   sync.Once
 	mutex  sync.Mutex
 	closed chan struct{}
+	flow   map[uuid.UUID]*flowControl
 }
 
 // NewResponseChannel returns a new ResponseChannel.
@@ -119,6 +122,8 @@ func (c *ResponseChannel) Closed() bool {
 
 // Write sends a message through the ResponseChannel.
 // Stream handlers are configured to pass this message back to the requester if possible.
+// If the requester controls the flow of the stream Write blocks until it has granted a credit for the message,
+// ErrContextCancelled is returned if the job is done while waiting.
 func (c *ResponseChannel) Write(msg *Response) (err error) {
 	defer func() {
 		if recover() != nil {
@@ -126,6 +131,14 @@ func (c *ResponseChannel) Write(msg *Response) (err error) {
 		}
 	}()
 
+	// Errors, such as EOF, end the stream and are never held back
+	if flow := c.flow[msg.JobId]; flow != nil && msg.Error == nil {
+		err = flow.acquire()
+		if err != nil {
+			return err
+		}
+	}
+
 	for !c.Closed() {
 		select {
 		case <-c.closed:
@@ -139,6 +152,15 @@ func (c *ResponseChannel) Write(msg *Response) (err error) {
 	return ErrChanClosed
 }
 
+// setFlowControl puts the responses of a job under flow control, it must be called before any message is written.
+func (c *ResponseChannel) setFlowControl(jobId uuid.UUID, flow *flowControl) {
+	if c.flow == nil {
+		c.flow = make(map[uuid.UUID]*flowControl)
+	}
+
+	c.flow[jobId] = flow
+}
+
 func (c *ResponseChannel) read() (*Response, error) {
 	msg, ok := <-c.ch
 	if !ok {
@@ -147,3 +169,93 @@ func (c *ResponseChannel) read() (*Response, error) {
 
 	return msg, nil
 }
+
+// RequestChannel is passed to bidirectional stream handlers to receive the follow-up messages the requester sends to the stream.
+type RequestChannel struct {
+	mutex  sync.Mutex
+	queue  []*Request
+	closed bool
+	ready  chan struct{}
+	done   <-chan struct{}
+}
+
+// NewRequestChannel returns a new RequestChannel, reads are aborted once done is closed.
+func NewRequestChannel(done <-chan struct{}) *RequestChannel {
+	return &RequestChannel{
+		ready: make(chan struct{}, 1),
+		done:  done,
+	}
+}
+
+// Close half-closes the RequestChannel, messages already received can still be read.
+func (c *RequestChannel) Close() {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+
+	c.signal()
+}
+
+// Closed checks wether or not the requester has half-closed the RequestChannel.
+func (c *RequestChannel) Closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+// Read waits for the next message from the requester.
+// ErrChanClosed is returned once the requester has half-closed the channel and all messages are read, or when the job is done.
+func (c *RequestChannel) Read() (*Request, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mutex.Unlock()
+
+			return msg, nil
+		}
+		closed := c.closed
+		c.mutex.Unlock()
+
+		if closed {
+			return nil, ErrChanClosed
+		}
+
+		select {
+		case <-c.ready:
+		case <-c.done:
+			return nil, ErrChanClosed
+		}
+	}
+}
+
+// write queues a message without blocking, so that a slow reader never stalls the connection.
+func (c *RequestChannel) write(msg *Request) error {
+	select {
+	case <-c.done:
+		return ErrChanClosed
+	default:
+	}
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return ErrChanClosed
+	}
+	c.queue = append(c.queue, msg)
+	c.mutex.Unlock()
+
+	c.signal()
+
+	return nil
+}
+
+func (c *RequestChannel) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
@@ -552,3 +552,96 @@ func TestResponseChannel_read(t *testing.T) {
 	}
 
 }
+
+func TestRequestChannel_Read(t *testing.T) {
+	tt := []struct {
+		name          string
+		preparation   func(ch *RequestChannel, done chan struct{})
+		expectedMsgs  int
+		expectedErr   error
+		expectTimeout bool
+	}{
+		{name: "Read without writer", expectTimeout: true},
+		{name: "Read queued messages",
+			preparation: func(ch *RequestChannel, done chan struct{}) {
+				_ = ch.write(&Request{})
+				_ = ch.write(&Request{})
+			},
+			expectedMsgs:  2,
+			expectTimeout: true,
+		},
+		{name: "Read half-closed chan", expectedMsgs: 1, expectedErr: ErrChanClosed,
+			preparation: func(ch *RequestChannel, done chan struct{}) {
+				_ = ch.write(&Request{})
+				ch.Close()
+			},
+		},
+		{name: "Read done chan", expectedErr: ErrChanClosed,
+			preparation: func(ch *RequestChannel, done chan struct{}) {
+				close(done)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			done := make(chan struct{})
+			ch := NewRequestChannel(done)
+
+			if tc.preparation != nil {
+				tc.preparation(ch, done)
+			}
+
+			for i := 0; i < tc.expectedMsgs; i++ {
+				msg, err := ch.Read()
+				if err != nil || msg == nil {
+					t.Fatalf("expected msg %d; got %v", i, err)
+				}
+			}
+
+			retc := make(chan error, 1)
+			go func() {
+				_, err := ch.Read()
+				retc <- err
+			}()
+
+			select {
+			case <-time.After(500 * time.Millisecond):
+				if !tc.expectTimeout {
+					t.Fatal("unexpected timeout")
+				}
+			case err := <-retc:
+				if err != tc.expectedErr {
+					t.Fatalf("expected err to be %v; got %v", tc.expectedErr, err)
+				}
+
+				if tc.expectTimeout {
+					t.Fatalf("expected timeout; got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestChannel_write(t *testing.T) {
+	done := make(chan struct{})
+	ch := NewRequestChannel(done)
+
+	err := ch.write(&Request{})
+	if err != nil {
+		t.Fatalf("unexpected err while writing: %v", err)
+	}
+
+	ch.Close()
+	err = ch.write(&Request{})
+	if err != ErrChanClosed {
+		t.Fatalf("expected %v writing to a half-closed chan; got %v", ErrChanClosed, err)
+	}
+
+	ch = NewRequestChannel(done)
+	close(done)
+	err = ch.write(&Request{})
+	if err != ErrChanClosed {
+		t.Fatalf("expected %v writing to a done chan; got %v", ErrChanClosed, err)
+	}
+}
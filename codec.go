@@ -0,0 +1,96 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errCodecNotFound = errors.New("codec not found")
+)
+
+// Codec is used to decode the requests and encode the responses of a connection.
+type Codec interface {
+	// Name identifies the codec when a client picks it for a web socket connection.
+	Name() string
+	// ContentType identifies the codec for long poll requests and responses.
+	ContentType() string
+	// FrameType is the web socket message type carrying data of the codec.
+	FrameType() int
+	// IsBatch reports whether or not data holds a list of requests rather than a single request.
+	IsBatch(data []byte) bool
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes messages as json carried by text frames, it is the default codec.
+	JSONCodec Codec = jsonCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) IsBatch(data []byte) bool {
+	return len(data) > 0 && data[0] == '['
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// SetCodec registers a codec which clients can pick for their connections.
+// Web socket clients pick a codec by its name through the codec query parameter, e.g. ws://host/?codec=msgpack,
+// long poll clients pick a codec by its content type through the Content-Type header.
+func (r *Router) SetCodec(codec Codec) {
+	r.codecs[codec.Name()] = codec
+}
+
+// codecFor returns the codec picked by the client for the connection started by req.
+func (r *Router) codecFor(req *http.Request) (Codec, error) {
+	if req.Method == http.MethodGet {
+		name := req.URL.Query().Get("codec")
+		if name == "" {
+			return JSONCodec, nil
+		}
+
+		codec, exists := r.codecs[name]
+		if !exists {
+			return nil, errCodecNotFound
+		}
+
+		return codec, nil
+	}
+
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return JSONCodec, nil
+	}
+
+	for _, codec := range r.codecs {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+
+	return JSONCodec, nil
+}
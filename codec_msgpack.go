@@ -0,0 +1,443 @@
+package wsrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+	"github.com/vmihailenco/msgpack/codes"
+)
+
+var (
+	// MessagePackCodec encodes messages as MessagePack carried by binary frames.
+	// Params, results and event data are kept as raw MessagePack, handlers decode and encode them through the codec of their connection.
+	MessagePackCodec Codec = msgpackCodec{}
+
+	errMsgpackTrailingData = errors.New("msgpack: trailing data after value")
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) IsBatch(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	c := codes.Code(data[0])
+
+	return codes.IsFixedArray(c) || c == codes.Array16 || c == codes.Array32
+}
+
+// Marshal encodes the wsrpc messages field by field, writing their raw params and results as they are.
+// Raw values holding json rather than MessagePack, e.g. results encoded by handlers with encoding/json, are converted.
+// Any other value is encoded with its json field names.
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf).UseJSONTag(true)
+
+	err := encodeMsgpack(&buf, enc, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return unambiguousMsgpack(buf.Bytes()), nil
+}
+
+// Unmarshal decodes the wsrpc messages field by field, keeping their raw params and results as MessagePack.
+// Any other value is decoded by its json field names.
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := newMsgpackReader(data)
+
+	var err error
+	switch m := v.(type) {
+	case *Request:
+		err = dec.decodeRequest(m)
+	case *[]Request:
+		err = dec.decodeRequests(m)
+	case *Response:
+		err = dec.decodeResponse(m)
+	default:
+		err = dec.Decode(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dec.r.Len() > 0 {
+		return errMsgpackTrailingData
+	}
+
+	return nil
+}
+
+func encodeMsgpack(buf *bytes.Buffer, enc *msgpack.Encoder, v interface{}) error {
+	switch m := v.(type) {
+	case *Response:
+		return encodeMsgpackResponse(buf, enc, m)
+	case []*Response:
+		err := enc.EncodeArrayLen(len(m))
+		if err != nil {
+			return err
+		}
+
+		for _, res := range m {
+			err = encodeMsgpackResponse(buf, enc, res)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case *Request:
+		return encodeMsgpackFields(buf, enc,
+			"id", m.Id,
+			"jobId", m.JobId.String(),
+			"method", m.Method,
+			"type", string(m.Type),
+			"params", m.Params,
+			"header", m.Header,
+		)
+	case *Event:
+		return encodeMsgpackFields(buf, enc,
+			"type", string(m.Type),
+			"event", m.Event,
+			"data", m.Data,
+			"header", m.Header,
+		)
+	}
+
+	return enc.Encode(v)
+}
+
+func encodeMsgpackResponse(buf *bytes.Buffer, enc *msgpack.Encoder, res *Response) error {
+	if res == nil {
+		return enc.EncodeNil()
+	}
+
+	var rspErr interface{}
+	if res.Error != nil {
+		rspErr = res.Error
+	}
+
+	fields := []interface{}{
+		"id", res.Id,
+		"jobId", res.JobId.String(),
+		"result", res.Result,
+		"header", res.Header,
+		"error", rspErr,
+	}
+	if res.Seq > 0 {
+		fields = append(fields, "seq", res.Seq)
+	}
+
+	return encodeMsgpackFields(buf, enc, fields...)
+}
+
+// encodeMsgpackFields encodes a map from alternating keys and values.
+// Raw messages are written as they are, except for the data of errors which is json as errors are created without a connection.
+func encodeMsgpackFields(buf *bytes.Buffer, enc *msgpack.Encoder, keyValues ...interface{}) error {
+	err := enc.EncodeMapLen(len(keyValues) / 2)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		err = enc.EncodeString(keyValues[i].(string))
+		if err != nil {
+			return err
+		}
+
+		switch value := keyValues[i+1].(type) {
+		case json.RawMessage:
+			err = encodeMsgpackRaw(buf, enc, value)
+		case *Error:
+			var data interface{}
+			if len(value.Data) > 0 {
+				err = json.Unmarshal(value.Data, &data)
+				if err != nil {
+					data = string(value.Data)
+				}
+			}
+
+			err = encodeMsgpackFields(buf, enc,
+				"code", value.Code,
+				"message", value.Message,
+				"data", data,
+			)
+		default:
+			err = enc.Encode(value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeMsgpackRaw writes raw, which has to hold a single MessagePack value, as it is.
+// Raw json is converted to MessagePack, the codec never produces MessagePack which is valid json, see unambiguousMsgpack.
+func encodeMsgpackRaw(buf *bytes.Buffer, enc *msgpack.Encoder, raw []byte) error {
+	if len(raw) == 0 {
+		return enc.EncodeNil()
+	}
+
+	if json.Valid(raw) {
+		return encodeMsgpackJSON(enc, raw)
+	}
+
+	r := bytes.NewReader(raw)
+	err := msgpack.NewDecoder(r).Skip()
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return errMsgpackTrailingData
+	}
+
+	_, err = buf.Write(raw)
+
+	return err
+}
+
+// encodeMsgpackJSON converts raw, a json value, to MessagePack.
+func encodeMsgpackJSON(enc *msgpack.Encoder, raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return err
+	}
+
+	return enc.Encode(jsonNumbers(v))
+}
+
+// jsonNumbers replaces the json numbers within v by integers, or floats if they are not integers.
+func jsonNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+
+		f, _ := value.Float64()
+		return f
+	case []interface{}:
+		for i := range value {
+			value[i] = jsonNumbers(value[i])
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = jsonNumbers(value[key])
+		}
+	}
+
+	return v
+}
+
+// unambiguousMsgpack returns raw, a single MessagePack value, such that it is not valid json.
+// Only the positive fixints 48 to 57 are, their single bytes read as the json digits 0 to 9, so they are encoded as uint8 instead.
+func unambiguousMsgpack(raw []byte) []byte {
+	if len(raw) == 1 && raw[0] >= '0' && raw[0] <= '9' {
+		return []byte{byte(codes.Uint8), raw[0]}
+	}
+
+	return raw
+}
+
+// msgpackReader decodes MessagePack while keeping track of its position in data, so that raw values can be taken from data.
+type msgpackReader struct {
+	*msgpack.Decoder
+	r    *bytes.Reader
+	data []byte
+}
+
+func newMsgpackReader(data []byte) *msgpackReader {
+	r := bytes.NewReader(data)
+
+	return &msgpackReader{
+		Decoder: msgpack.NewDecoder(r).UseJSONTag(true),
+		r:       r,
+		data:    data,
+	}
+}
+
+// raw returns a copy of the next value as raw MessagePack, or nil if it is nil.
+// The copy is never valid json, so that it is passed on as it is when encoded.
+func (d *msgpackReader) raw() (json.RawMessage, error) {
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	if c == codes.Nil {
+		return nil, d.Skip()
+	}
+
+	start := len(d.data) - d.r.Len()
+	err = d.Skip()
+	if err != nil {
+		return nil, err
+	}
+	end := len(d.data) - d.r.Len()
+
+	return append(json.RawMessage(nil), unambiguousMsgpack(d.data[start:end])...), nil
+}
+
+func (d *msgpackReader) decodeUUID() (uuid.UUID, error) {
+	s, err := d.DecodeString()
+	if err != nil || s == "" {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(s)
+}
+
+func (d *msgpackReader) decodeRequests(requests *[]Request) error {
+	n, err := d.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+
+	*requests = nil
+	for i := 0; i < n; i++ {
+		req := newRequest()
+		err = d.decodeRequest(&req)
+		if err != nil {
+			return err
+		}
+
+		*requests = append(*requests, req)
+	}
+
+	return nil
+}
+
+// decodeRequest decodes a request following the rules of its json decoding, such as the generation of missing job ids.
+func (d *msgpackReader) decodeRequest(req *Request) error {
+	n, err := d.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		key, err := d.DecodeString()
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "id":
+			req.Id, err = d.DecodeInt()
+		case "jobId":
+			req.JobId, err = d.decodeUUID()
+		case "method":
+			req.Method, err = d.DecodeString()
+		case "type":
+			var t string
+			t, err = d.DecodeString()
+			req.Type = RequestType(t)
+		case "params":
+			req.Params, err = d.raw()
+		case "header":
+			err = d.Decode(&req.Header)
+		default:
+			err = d.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if req.JobId == uuid.Nil {
+		req.JobId = uuid.New()
+	}
+
+	return nil
+}
+
+func (d *msgpackReader) decodeResponse(res *Response) error {
+	n, err := d.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		key, err := d.DecodeString()
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "id":
+			res.Id, err = d.DecodeInt()
+		case "jobId":
+			res.JobId, err = d.decodeUUID()
+		case "result":
+			res.Result, err = d.raw()
+		case "header":
+			err = d.Decode(&res.Header)
+		case "error":
+			res.Error, err = d.decodeError()
+		case "seq":
+			res.Seq, err = d.DecodeUint64()
+		default:
+			err = d.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeError decodes an error, its data is turned into json as errors are handled without a connection.
+func (d *msgpackReader) decodeError() (*Error, error) {
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	if c == codes.Nil {
+		return nil, d.Skip()
+	}
+
+	var fields struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data"`
+	}
+	err = d.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	rspErr := &Error{
+		Code:    fields.Code,
+		Message: fields.Message,
+	}
+	if fields.Data != nil {
+		rspErr.Data, err = json.Marshal(fields.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rspErr, nil
+}
@@ -0,0 +1,249 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrConnClosed is returned when trying to reach a client which is no longer connected
+	ErrConnClosed = errors.New("connection closed")
+	// ErrPushUnsupported is returned when pushing events to a client whose connection does not support it
+	ErrPushUnsupported = errors.New("push is not supported by the connection")
+	// ErrCallUnsupported is returned when calling methods on a client whose connection does not support it
+	ErrCallUnsupported = errors.New("calls are not supported by the connection")
+)
+
+type connKey struct{}
+
+// Conn is a handle to a client connection.
+// It allows the server to reach the client outside of any job, e.g. to push events.
+type Conn struct {
+	id   uuid.UUID
+	sock *socket
+
+	pending      map[uuid.UUID]chan *Response
+	pendingMutex sync.Mutex
+}
+
+func newConn(sock *socket) *Conn {
+	return &Conn{
+		id:      uuid.New(),
+		sock:    sock,
+		pending: make(map[uuid.UUID]chan *Response),
+	}
+}
+
+// Id returns the unique id of the connection.
+func (c *Conn) Id() uuid.UUID {
+	return c.id
+}
+
+// Context returns the connection context, it is cancelled when the connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.sock.ctx
+}
+
+// HttpRequest returns the original HTTP request that started the connection.
+func (c *Conn) HttpRequest() *http.Request {
+	return c.sock.req
+}
+
+// Protocol returns the protocol negotiated by the client.
+func (c *Conn) Protocol() *Protocol {
+	return c.sock.protocol
+}
+
+// Codec returns the codec of the connection, raw params, results and event data are encoded with it.
+func (c *Conn) Codec() Codec {
+	return c.sock.codec
+}
+
+// Session returns the session the connection belongs to, or nil if sessions are not enabled.
+func (c *Conn) Session() *Session {
+	return c.sock.session
+}
+
+// Push sends an event to the client.
+// The event is left as it is, so the same event can be pushed to many connections at once.
+// Only clients connected through web sockets with a protocol supporting FeaturePush can receive events.
+func (c *Conn) Push(event *Event) error {
+	if c.sock.conn == nil || !c.sock.protocol.Supports(FeaturePush) {
+		return ErrPushUnsupported
+	}
+
+	if c.sock.ctx.Err() != nil {
+		return ErrConnClosed
+	}
+
+	msg := *event
+	msg.Type = TypeEvent
+	if msg.Header == nil {
+		msg.Header = NewHeader()
+	}
+
+	err := c.sock.channel.write(&msg)
+	if err == ErrChanClosed {
+		return ErrConnClosed
+	}
+
+	return err
+}
+
+// Call invokes a method registered on the client and waits for its response.
+// The params are encoded with the codec of the connection unless they already are a json.RawMessage, which is passed on as it is.
+// If the client responds with an error it is returned along with the response.
+// Only clients connected through web sockets with a protocol supporting FeatureClientCalls can be called.
+func (c *Conn) Call(ctx context.Context, method string, params interface{}) (*Response, error) {
+	if c.sock.conn == nil || !c.sock.protocol.Supports(FeatureClientCalls) {
+		return nil, ErrCallUnsupported
+	}
+
+	req := newRequest()
+	req.JobId = uuid.New()
+	req.Method = method
+	req.Type = TypeCall
+
+	var err error
+	switch p := params.(type) {
+	case nil:
+	case json.RawMessage:
+		req.Params = p
+	default:
+		req.Params, err = c.sock.codec.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resc := make(chan *Response, 1)
+	c.pendingMutex.Lock()
+	c.pending[req.JobId] = resc
+	c.pendingMutex.Unlock()
+
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, req.JobId)
+		c.pendingMutex.Unlock()
+	}()
+
+	err = c.sock.channel.write(&req)
+	if err == ErrChanClosed {
+		return nil, ErrConnClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.sock.ctx.Done():
+		return nil, ErrConnClosed
+	case res := <-resc:
+		if res.Error != nil {
+			return res, res.Error
+		}
+
+		return res, nil
+	}
+}
+
+// resolve passes data to a pending call if it is the clients response to it.
+// It reports whether or not data was consumed as such a response.
+func (c *Conn) resolve(data []byte) bool {
+	c.pendingMutex.Lock()
+	awaiting := len(c.pending) > 0
+	c.pendingMutex.Unlock()
+
+	if !awaiting || len(data) == 0 || c.sock.codec.IsBatch(data) {
+		return false
+	}
+
+	// Responses are told apart from requests by their lack of type and method
+	var probe struct {
+		Type   RequestType `json:"type"`
+		Method string      `json:"method"`
+	}
+	err := c.sock.codec.Unmarshal(data, &probe)
+	if err != nil || probe.Type != "" || probe.Method != "" {
+		return false
+	}
+
+	var res Response
+	err = c.sock.codec.Unmarshal(data, &res)
+	if err != nil {
+		return false
+	}
+
+	c.pendingMutex.Lock()
+	resc, exists := c.pending[res.JobId]
+	delete(c.pending, res.JobId)
+	c.pendingMutex.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	resc <- &res
+
+	return true
+}
+
+// Conns returns all clients currently connected to the router through web sockets.
+func (r *Router) Conns() []*Conn {
+	r.connsMutex.RLock()
+	defer r.connsMutex.RUnlock()
+
+	conns := make([]*Conn, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
+
+// Conn returns the connected client with the given id.
+func (r *Router) Conn(id uuid.UUID) (*Conn, bool) {
+	r.connsMutex.RLock()
+	defer r.connsMutex.RUnlock()
+
+	conn, exists := r.conns[id]
+
+	return conn, exists
+}
+
+// SetConnectHook registers a func which is called when a client has connected through web sockets.
+func (r *Router) SetConnectHook(fn func(conn *Conn)) {
+	r.onConnect = fn
+}
+
+// SetDisconnectHook registers a func which is called when a web socket client has disconnected.
+func (r *Router) SetDisconnectHook(fn func(conn *Conn)) {
+	r.onDisconnect = fn
+}
+
+func (r *Router) connect(conn *Conn) {
+	r.connsMutex.Lock()
+	r.conns[conn.id] = conn
+	r.connsMutex.Unlock()
+
+	if r.onConnect != nil {
+		r.onConnect(conn)
+	}
+}
+
+func (r *Router) disconnect(conn *Conn) {
+	r.connsMutex.Lock()
+	delete(r.conns, conn.id)
+	r.connsMutex.Unlock()
+
+	if r.onDisconnect != nil {
+		r.onDisconnect(conn)
+	}
+}
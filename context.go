@@ -2,7 +2,6 @@ package wsrpc
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"net/http"
 	"sync"
@@ -14,6 +13,14 @@ import (
 var (
 	// ErrContextCancelled is returned when detecting a cancelled context
 	ErrContextCancelled = errors.New("context cancelled")
+	// ErrJobCancelled is the cause of a job context cancelled on request of the client
+	ErrJobCancelled = errors.New("job cancelled")
+	// ErrJobTimeout is the cause of a job context cancelled because the timeout of the job passed
+	ErrJobTimeout = errors.New("job timed out")
+	// ErrClientDisconnected is the cause of contexts cancelled because the client connection was lost
+	ErrClientDisconnected = errors.New("client disconnected")
+	// ErrLongPollAnswered is the cause of contexts cancelled because the long poll request has been answered
+	ErrLongPollAnswered = errors.New("long poll answered")
 )
 
 // Context is passed to request handlers with data that might be needed to handle the request.
@@ -25,145 +32,290 @@ type Context interface {
 	Response() *Response
 	NewResponse() *Response
 	HttpRequest() *http.Request
+	Conn() *Conn
+	Protocol() *Protocol
+	Codec() Codec
+	Session() *Session
 	WithValue(key interface{}, value interface{}) Context
 }
 
 type socket struct {
-	ctx    context.Context
-	cancel func()
-	once   sync.Once
+	protocol *Protocol
+	codec    Codec
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	stop     func() bool
+	once     sync.Once
 
 	conn *websocket.Conn
 	w    http.ResponseWriter
 	req  *http.Request
 
+	handle  *Conn
+	session *Session
+	limiter *jobLimiter
 	channel *InfChannel
-	batches []*batch
+
+	batches      []*batch
+	batchesMutex sync.Mutex
 }
 
 func newSocket(w http.ResponseWriter, req *http.Request) *socket {
-	ctx, cancel := context.WithCancel(req.Context())
-	return &socket{
-		ctx:     ctx,
-		cancel:  cancel,
-		w:       w,
-		req:     req,
-		channel: NewInfChannel(),
-		batches: make([]*batch, 0),
+	// The socket keeps the values of the HTTP request context but replaces its cancellation,
+	// this allows the cancellation to carry a cause down to batches and jobs.
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(req.Context()))
+	stop := context.AfterFunc(req.Context(), func() {
+		cancel(ErrClientDisconnected)
+	})
+
+	sock := &socket{
+		protocol: ProtocolV1,
+		codec:    JSONCodec,
+		cancel:   cancel,
+		stop:     stop,
+		w:        w,
+		req:      req,
+		channel:  NewInfChannel(),
+		batches:  make([]*batch, 0),
 	}
+	sock.handle = newConn(sock)
+	sock.ctx = context.WithValue(ctx, connKey{}, sock.handle)
+
+	return sock
 }
 
-func (s *socket) kill() {
-	s.once.Do(func() {
-		for i := range s.batches {
-			s.batches[i].kill()
+// addBatch adds running batches to the socket, so that their jobs can be addressed by the client and they are killed along with the socket.
+func (s *socket) addBatch(batches ...*batch) {
+	s.batchesMutex.Lock()
+	defer s.batchesMutex.Unlock()
+
+	s.batches = append(s.batches, batches...)
+}
+
+// removeBatch removes a batch which is done from the socket, so that the socket only holds on to running batches.
+func (s *socket) removeBatch(batch *batch) {
+	s.batchesMutex.Lock()
+	defer s.batchesMutex.Unlock()
+
+	for i := range s.batches {
+		if s.batches[i] == batch {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+			return
 		}
+	}
+}
+
+// job returns the job with the given id from any of the sockets batches, or nil if there is no such job.
+// Batches are searched latest first, as a JobId may have been used by a batch which has already ended.
+func (s *socket) job(id uuid.UUID) *job {
+	s.batchesMutex.Lock()
+	defer s.batchesMutex.Unlock()
+
+	for i := len(s.batches) - 1; i >= 0; i-- {
+		job := s.batches[i].job(id)
+		if job != nil {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// kill cancels the socket and all of its batches with the supplied cause.
+func (s *socket) kill(cause error) {
+	s.once.Do(func() {
+		s.stop()
+		s.cancel(cause)
 		s.channel.clear()
-		s.cancel()
+
+		s.batchesMutex.Lock()
+		batches := append([]*batch(nil), s.batches...)
+		s.batchesMutex.Unlock()
+
+		for _, batch := range batches {
+			// Resumable streams keep running without the connection for a while
+			if batch.resumption != nil {
+				batch.resumption.detach(s)
+				continue
+			}
+			// So do calls kept by a session
+			if batch.session != nil {
+				continue
+			}
+
+			batch.kill(cause)
+		}
 	})
 
 }
 
 type batch struct {
 	ctx    context.Context
-	cancel func()
+	cancel context.CancelCauseFunc
+	stop   func() bool
 	once   sync.Once
 
-	isSlice  bool `json:"-"`
-	isStream bool `json:"-"`
+	isSlice   bool `json:"-"`
+	isStream  bool `json:"-"` // The batch contains streams, its responses are passed on as they come
+	isControl bool `json:"-"`
+	isJSONRPC bool `json:"-"`
 
-	channel *ResponseChannel
-	jobs    []job
+	// isIncremental batches pass on the result of each job as soon as it is done
+	isIncremental bool `json:"-"`
+	// isOrdered batches pass on the results of their jobs in request order
+	isOrdered bool `json:"-"`
+
+	channel    *ResponseChannel
+	resumption *resumption
+	limiter    *jobLimiter
+	jobs       []job
+
+	// session keeps the batch running when its connection is gone, its output is then passed on in codec
+	session *Session
+	codec   Codec
 }
 
-func createBatch(data []byte, httpRequest *http.Request) (*batch, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	batch := batch{
-		ctx:    ctx,
-		cancel: cancel,
-	}
+// createBatch decodes the requests in data into a batch of jobs.
+// The batch context is derived from parent and each job context is derived from the batch context.
+func createBatch(parent context.Context, codec Codec, data []byte, httpRequest *http.Request) (*batch, error) {
+	batch := batch{}
 
-	if len(data) > 0 && data[0] == '[' {
+	var requests []Request
+	if len(data) > 0 && codec.IsBatch(data) {
 		batch.isSlice = true
-		var requests []Request
-		err := json.Unmarshal(data, &requests)
+		err := codec.Unmarshal(data, &requests)
 		if err != nil {
 			return nil, err
 		}
-
-		for i := range requests {
-			req := requests[i]
-
-			ctx, cancel := context.WithCancel(context.Background())
-			ctx = context.WithValue(ctx, "rpcId", req.JobId.String())
-
-			batch.jobs = append(batch.jobs, job{
-				Context:     ctx,
-				cancel:      cancel,
-				request:     &req,
-				response:    newResponse(req.Id, req.JobId, nil),
-				httpRequest: httpRequest,
-			})
-		}
 	}
 
-	if len(data) > 0 && data[0] == '{' {
+	if len(data) > 0 && !codec.IsBatch(data) {
 		batch.isSlice = false
 
 		req := newRequest()
-		err := json.Unmarshal(data, &req)
+		err := codec.Unmarshal(data, &req)
 		if err != nil {
 			return nil, err
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		ctx = context.WithValue(ctx, "rpcId", req.JobId.String())
-
-		batch.jobs = []job{
-			{
-				Context:     ctx,
-				cancel:      cancel,
-				request:     &req,
-				response:    &Response{Id: req.Id, JobId: req.JobId},
-				httpRequest: httpRequest,
-			},
-		}
+		requests = []Request{req}
 	}
 
-	if len(batch.jobs) < 1 {
+	if len(requests) < 1 {
 		return nil, errMissingRequest
 	}
 
-	batch.isStream = batch.jobs[0].request.Type == TypeStream
-	for _, job := range batch.jobs {
-		if job.request.Id == 0 && job.request.JobId == uuid.Nil {
+	batch.isControl = requests[0].Type.isControl()
+	for i := range requests {
+		req := &requests[i]
+		req.notification = req.Type == TypeNotify
+
+		if req.Id == 0 && req.JobId == uuid.Nil && !req.notification {
 			return nil, errMissingRequestId
 		}
 
-		if (job.request.Type == TypeStream) != batch.isStream {
+		// Calls and streams can be mixed, but requests addressing running jobs can't be mixed with requests starting new ones
+		if req.Type.isControl() != batch.isControl {
 			return nil, errMixedTypes
 		}
+
+		if req.Type == TypeStream {
+			batch.isStream = true
+		}
 	}
 
-	batch.channel = NewResponseChannel(len(batch.jobs))
+	batch.start(parent, requests, httpRequest)
 
 	return &batch, nil
 }
 
-func (b *batch) kill() {
+// start derives the batch context from parent and creates a job, with a context derived from the batch context, per request.
+// Stream batches follow the cancellation of parent rather than inheriting it, which allows them to outlive their connection when resumable.
+func (b *batch) start(parent context.Context, requests []Request, httpRequest *http.Request) {
+	// Streams, and calls within a session, can outlive their connection
+	if _, inSession := parent.Value(sessionKey{}).(*Session); b.isStream || inSession {
+		b.ctx, b.cancel = context.WithCancelCause(context.WithoutCancel(parent))
+		b.stop = context.AfterFunc(parent, func() {
+			b.kill(context.Cause(parent))
+		})
+	} else {
+		b.ctx, b.cancel = context.WithCancelCause(parent)
+		b.stop = func() bool { return true }
+	}
+	for i := range requests {
+		req := &requests[i]
+
+		ctx, cancel := context.WithCancelCause(b.ctx)
+		ctx = context.WithValue(ctx, "rpcId", req.JobId.String())
+
+		var inbound *RequestChannel
+		if req.Type == TypeStream {
+			inbound = NewRequestChannel(ctx.Done())
+		}
+
+		b.jobs = append(b.jobs, job{
+			Context:     ctx,
+			cancel:      cancel,
+			answered:    &sync.Once{},
+			inbound:     inbound,
+			request:     req,
+			response:    newResponse(req.Id, req.JobId, nil),
+			httpRequest: httpRequest,
+		})
+	}
+
+	b.channel = NewResponseChannel(len(b.jobs))
+}
+
+// job returns the job with the given id, or nil if the batch contains no such job.
+func (b *batch) job(id uuid.UUID) *job {
+	for i := range b.jobs {
+		if b.jobs[i].request.JobId == id {
+			return &b.jobs[i]
+		}
+	}
+
+	return nil
+}
+
+// kill cancels the batch and all of its jobs with the supplied cause.
+func (b *batch) kill(cause error) {
 	b.once.Do(func() {
+		b.stop()
+		b.cancel(cause)
 		for i := range b.jobs {
-			b.jobs[i].kill()
+			b.jobs[i].kill(cause)
 		}
 		b.channel.Close()
-		b.cancel()
 	})
 }
 
-func (b *batch) killJob(id uuid.UUID) {
+// jobIndex returns the index of the job with the given id, or -1 if the batch contains no such job.
+func (b *batch) jobIndex(id uuid.UUID) int {
+	for i := range b.jobs {
+		if b.jobs[i].request.JobId == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// hasNotifications reports whether or not any of the jobs of the batch is a notification.
+func (b *batch) hasNotifications() bool {
+	for i := range b.jobs {
+		if b.jobs[i].request.notification {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *batch) killJob(id uuid.UUID, cause error) {
 	for i := range b.jobs {
 		if b.jobs[i].request.JobId == id {
-			b.jobs[i].kill()
+			b.jobs[i].kill(cause)
 		}
 	}
 }
@@ -171,15 +323,45 @@ func (b *batch) killJob(id uuid.UUID) {
 type job struct {
 	context.Context
 
-	cancel      func()
-	once        sync.Once
+	cancel      context.CancelCauseFunc
+	answered    *sync.Once
+	invalid     *Error
+	inbound     *RequestChannel
+	flow        *flowControl
+	resumption  *resumption
 	request     *Request
 	httpRequest *http.Request
 	response    *Response
 }
 
-func (j *job) kill() {
-	j.once.Do(j.cancel)
+func (j job) kill(cause error) {
+	j.cancel(cause)
+}
+
+// answer writes the final response of the job to jobc, unless the job has already been answered,
+// e.g. with a timeout error before its handler returned.
+func (j job) answer(jobc *ResponseChannel, rsp *Response) (err error) {
+	j.answered.Do(func() {
+		err = jobc.Write(rsp)
+	})
+
+	return err
+}
+
+// cancelled reports whether or not the job was cancelled on request of the client.
+func (j job) cancelled() bool {
+	return context.Cause(j.Context) == ErrJobCancelled
+}
+
+// placeholder returns the response standing in for the result of a job which ended without one.
+func (j job) placeholder() *Response {
+	rsp := j.NewResponse()
+	rsp.Error = ServerError(errNoResponse)
+	if j.cancelled() {
+		rsp.Error = Cancelled()
+	}
+
+	return rsp
 }
 
 // NewResponse returns a new response which can be returned to the requester passively or by writing into a ResponseChannel.
@@ -208,3 +390,33 @@ func (j job) WithValue(key interface{}, value interface{}) Context {
 func (j job) HttpRequest() *http.Request {
 	return j.httpRequest
 }
+
+// Conn returns the connection the job was requested through, or the connection a resumed stream is attached to.
+func (j job) Conn() *Conn {
+	if j.resumption != nil {
+		if conn := j.resumption.conn(); conn != nil {
+			return conn
+		}
+	}
+
+	conn, _ := j.Value(connKey{}).(*Conn)
+
+	return conn
+}
+
+// Protocol returns the protocol of the connection the job was requested through.
+func (j job) Protocol() *Protocol {
+	return j.Conn().Protocol()
+}
+
+// Codec returns the codec of the connection the job was requested through, params and results are raw in its encoding.
+func (j job) Codec() Codec {
+	return j.Conn().Codec()
+}
+
+// Session returns the session of the client, or nil if sessions are not enabled.
+func (j job) Session() *Session {
+	session, _ := j.Value(sessionKey{}).(*Session)
+
+	return session
+}
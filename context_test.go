@@ -0,0 +1,126 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCreateBatch_cancellationCascade(t *testing.T) {
+	errParent := errors.New("parent cancelled")
+	data := []byte(`[{"id":1,"type":"CALL","method":"a"},{"id":2,"type":"CALL","method":"b"}]`)
+
+	tt := []struct {
+		name     string
+		cancel   func(parent context.CancelCauseFunc, b *batch)
+		expected []error
+	}{
+		{
+			name: "parent cancelled",
+			cancel: func(parent context.CancelCauseFunc, b *batch) {
+				parent(errParent)
+			},
+			expected: []error{errParent, errParent},
+		},
+		{
+			name: "batch killed",
+			cancel: func(parent context.CancelCauseFunc, b *batch) {
+				b.kill(ErrClientDisconnected)
+			},
+			expected: []error{ErrClientDisconnected, ErrClientDisconnected},
+		},
+		{
+			name: "job cancelled",
+			cancel: func(parent context.CancelCauseFunc, b *batch) {
+				b.job(b.jobs[1].request.JobId).kill(ErrJobCancelled)
+			},
+			expected: []error{nil, ErrJobCancelled},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			type key struct{}
+			parent, cancel := context.WithCancelCause(context.WithValue(context.Background(), key{}, "upstream"))
+			defer cancel(nil)
+
+			b, err := createBatch(parent, JSONCodec, data, httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("failed to create batch: %v", err)
+			}
+			defer b.kill(nil)
+
+			tc.cancel(cancel, b)
+
+			for i, job := range b.jobs {
+				if job.Value(key{}) != "upstream" {
+					t.Errorf("job %d lost upstream context values", i)
+				}
+
+				cause := context.Cause(job)
+				if cause != tc.expected[i] {
+					t.Errorf("job %d cause %v; expected %v", i, cause, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSocket_batchesRemoved(t *testing.T) {
+	router := NewRouter()
+	router.SetHandler("echo", func(ctx Context) error {
+		ctx.Response().Result = ctx.Request().Params
+		return nil
+	})
+
+	conns := make(chan *Conn, 1)
+	router.SetConnectHook(func(conn *Conn) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	sock := (<-conns).sock
+
+	for i := 0; i < 20; i++ {
+		err = conn.WriteJSON(Request{Id: i, Method: "echo", Type: TypeCall, Params: json.RawMessage(`1`)})
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		var res Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+	}
+
+	// Batches are removed once routed, which happens right after their last response is passed on
+	for i := 0; ; i++ {
+		sock.batchesMutex.Lock()
+		remaining := len(sock.batches)
+		sock.batchesMutex.Unlock()
+
+		if remaining == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expected the socket to let go of routed batches; got %d", remaining)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
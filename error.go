@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+
+	"github.com/google/uuid"
 )
 
 // Error contains all the necessary info when handling or returning an error in the wsrpc server.
@@ -14,12 +16,23 @@ type Error struct {
 	err     error           `json:"-"`
 }
 
+const (
+	codeEOF         = 205
+	codeCancelled   = 499
+	codeReplayGap   = 410
+	codeTimeout     = 408
+	codeLimit       = 413
+	codeRateLimited = 429
+	codeShutdown    = 503
+)
+
 var (
 	errMissingRequestId = errors.New("missing request id")
-	errUnsupportedFrame = errors.New("frame content is not text")
+	errUnsupportedFrame = errors.New("frame type does not match the connection codec")
 	errMissingRequest   = errors.New("missing request")
 	errMixedTypes       = errors.New("mixed types is not allowed")
 	errMethodNotFound   = errors.New("method not found")
+	errNoResponse       = errors.New("job ended without a response")
 )
 
 // Error is a stringer
@@ -27,6 +40,22 @@ func (r Error) Error() string {
 	return fmt.Sprintf("wsrpc: %v, message=%s", r.Code, r.Message)
 }
 
+// ParseError is called when a message can not be parsed.
+func ParseError() *Error {
+	return &Error{
+		Code:    -32700,
+		Message: "parse error",
+	}
+}
+
+// InvalidRequestError is called when a request is not valid.
+func InvalidRequestError(reason string) *Error {
+	return &Error{
+		Code:    -32600,
+		Message: fmt.Sprintf("invalid request: %s", reason),
+	}
+}
+
 // TypeNotFoundError is called when an invalid request type is requested.
 func TypeNotFoundError(t RequestType) *Error {
 	return &Error{
@@ -51,6 +80,14 @@ func IdIsRequiredError() *Error {
 	}
 }
 
+// JobNotFoundError is called when a request refers to a job that is not running.
+func JobNotFoundError(jobId uuid.UUID) *Error {
+	return &Error{
+		Code:    -32602,
+		Message: fmt.Sprintf("job not found: %s", jobId),
+	}
+}
+
 // ServerError repackages any regular error message into a wsrpc error which can be passed to a response.
 func ServerError(outpErr error) *Error {
 	errData, err := json.Marshal(outpErr)
@@ -67,7 +104,55 @@ func ServerError(outpErr error) *Error {
 // EOF is used to denote end of contents in stream requests.
 func EOF() *Error {
 	return &Error{
-		Code:    205,
+		Code:    codeEOF,
 		Message: "EOF",
 	}
 }
+
+// ReplayGapError is called when a stream can not be resumed because responses after seq are no longer buffered.
+func ReplayGapError(seq uint64) *Error {
+	return &Error{
+		Code:    codeReplayGap,
+		Message: fmt.Sprintf("responses after seq %d are no longer available", seq),
+	}
+}
+
+// TimeoutError is used to answer a job whose timeout passed before its handler was done.
+func TimeoutError() *Error {
+	return &Error{
+		Code:    codeTimeout,
+		Message: "timeout",
+	}
+}
+
+// LimitExceededError is called when a request exceeds one of the limits of the router.
+func LimitExceededError(reason string) *Error {
+	return &Error{
+		Code:    codeLimit,
+		Message: fmt.Sprintf("limit exceeded: %s", reason),
+	}
+}
+
+// ShutdownError is used to answer jobs which are rejected or ended because the router is shutting down.
+func ShutdownError() *Error {
+	return &Error{
+		Code:    codeShutdown,
+		Message: "server shutting down",
+	}
+}
+
+// RateLimitedError is called when a job exceeds its rate, the response carries a retryAfter header.
+func RateLimitedError() *Error {
+	return &Error{
+		Code:    codeRateLimited,
+		Message: "rate limited",
+	}
+}
+
+// Cancelled is used to acknowledge that a job was cancelled on request of the client.
+func Cancelled() *Error {
+	return &Error{
+		Code:    codeCancelled,
+		Message: "cancelled",
+	}
+}
@@ -0,0 +1,79 @@
+package wsrpc
+
+import (
+	"sync"
+)
+
+// creditsHeader is the request header through which a client grants a stream its initial credits.
+const creditsHeader = "credits"
+
+// flowControl limits the number of responses a stream may send to the credits granted by the client.
+type flowControl struct {
+	mutex   sync.Mutex
+	credits int64
+	granted chan struct{}
+	done    <-chan struct{}
+}
+
+func newFlowControl(credits int64, done <-chan struct{}) *flowControl {
+	if credits < 0 {
+		credits = 0
+	}
+
+	return &flowControl{
+		credits: credits,
+		granted: make(chan struct{}),
+		done:    done,
+	}
+}
+
+// acquire consumes a credit, waiting for the client to grant more if there are none left.
+// ErrContextCancelled is returned if done is closed while waiting.
+func (f *flowControl) acquire() error {
+	for {
+		f.mutex.Lock()
+		if f.credits > 0 {
+			f.credits--
+			f.mutex.Unlock()
+
+			return nil
+		}
+		granted := f.granted
+		f.mutex.Unlock()
+
+		select {
+		case <-granted:
+		case <-f.done:
+			return ErrContextCancelled
+		}
+	}
+}
+
+// grant adds n credits and wakes up everyone waiting for them.
+func (f *flowControl) grant(n int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.credits += n
+	close(f.granted)
+	f.granted = make(chan struct{})
+}
+
+// startFlowControl puts the stream jobs of the batch whose requests carry a credits header under flow control.
+// Streams without the header are not limited.
+func (b *batch) startFlowControl() {
+	for i := range b.jobs {
+		job := &b.jobs[i]
+		if job.request.Type != TypeStream {
+			continue
+		}
+
+		credits, ok := job.request.Header.Get(creditsHeader).Int()
+		if !ok {
+			continue
+		}
+
+		job.flow = newFlowControl(credits, job.Done())
+		b.channel.setFlowControl(job.request.JobId, job.flow)
+	}
+}
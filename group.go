@@ -0,0 +1,93 @@
+package wsrpc
+
+// Group registers methods under a common prefix, with middleware of its own.
+// The middleware of a group runs after the middleware of the router, or of the group it is nested in, and before the middleware of the method.
+type Group struct {
+	router     *Router
+	parent     *Group
+	prefix     string
+	middleware []Middleware
+}
+
+// Group returns a group registering its methods with the given prefix, e.g. billing.
+func (r *Router) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{
+		router:     r,
+		prefix:     prefix,
+		middleware: middleware,
+	}
+}
+
+// Group returns a group nested in g, its methods are registered with the prefix of g followed by the given prefix.
+func (g *Group) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{
+		router:     g.router,
+		parent:     g,
+		prefix:     g.prefix + prefix,
+		middleware: middleware,
+	}
+}
+
+// Use applies middleware to all methods of the group and the groups nested in it.
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// SetHandler registers a call handler func under the prefix of the group.
+func (g *Group) SetHandler(method string, handler CallHandler, middleware ...Middleware) *Registration {
+	g.router.rpcFunctions[g.prefix+method] = functionBundle{
+		bundle:   g.bundle(method, middleware),
+		function: handler,
+	}
+
+	return g.router.rpcFunctions[g.prefix+method].registration
+}
+
+// SetStream registers a stream handler func under the prefix of the group.
+func (g *Group) SetStream(method string, handler StreamHandler, middleware ...Middleware) *Registration {
+	g.router.rpcStreams[g.prefix+method] = streamBundle{
+		bundle: g.bundle(method, middleware),
+		stream: handler,
+	}
+
+	return g.router.rpcStreams[g.prefix+method].registration
+}
+
+// SetBidiStream registers a bidirectional stream handler func under the prefix of the group.
+func (g *Group) SetBidiStream(method string, handler BidiStreamHandler, middleware ...Middleware) *Registration {
+	g.router.rpcStreams[g.prefix+method] = streamBundle{
+		bundle: g.bundle(method, middleware),
+		bidi:   handler,
+	}
+
+	return g.router.rpcStreams[g.prefix+method].registration
+}
+
+func (g *Group) bundle(method string, middleware []Middleware) bundle {
+	return bundle{
+		method:       g.prefix + method,
+		middleware:   middleware,
+		group:        g,
+		registration: &Registration{},
+	}
+}
+
+// chain returns the middleware of the groups g is nested in followed by the middleware of g.
+func (g *Group) chain() []Middleware {
+	if g == nil {
+		return nil
+	}
+
+	return append(g.parent.chain(), g.middleware...)
+}
+
+// chain returns the middleware a job of the method of b passes through, in order.
+func (r *Router) chain(b bundle) []Middleware {
+	groups := b.group.chain()
+
+	chain := make([]Middleware, 0, len(r.middleware)+len(groups)+len(b.middleware))
+	chain = append(chain, r.middleware...)
+	chain = append(chain, groups...)
+
+	return append(chain, b.middleware...)
+}
@@ -0,0 +1,87 @@
+package wsrpc
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrPeerUnresponsive is the cause of contexts cancelled because the client stopped answering pings
+	ErrPeerUnresponsive = errors.New("peer stopped answering pings")
+	// ErrPeerIdle is reported when a client has not answered a ping by the time the next one is sent
+	ErrPeerIdle = errors.New("peer did not answer ping")
+)
+
+// SetHeartbeat makes the router ping web socket clients every interval.
+// Clients which have not answered a ping by the time the next one is sent are reported as idle with ErrPeerIdle.
+// Clients which neither answer a ping nor send anything else within the timeout are considered dead and their connection is torn down,
+// with ErrPeerUnresponsive as the cause of the connection context. An interval of zero disables heartbeats.
+// It panics if interval is negative, or if heartbeats are enabled without a positive timeout.
+func (r *Router) SetHeartbeat(interval, timeout time.Duration) {
+	if interval < 0 || (interval > 0 && timeout <= 0) {
+		panic("wsrpc: SetHeartbeat needs a positive interval and timeout")
+	}
+
+	r.pingInterval = interval
+	r.pongTimeout = timeout
+}
+
+// startHeartbeat pings the client of sock every ping interval until the connection is gone.
+// Every pong pushes the read deadline of the connection forward, reading past it means the client is gone.
+func (r *Router) startHeartbeat(sock *socket) {
+	var answered atomic.Bool
+	answered.Store(true)
+
+	r.extendReadDeadline(sock)
+	sock.conn.SetPongHandler(func(string) error {
+		answered.Store(true)
+		r.extendReadDeadline(sock)
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(r.pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sock.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !answered.Swap(false) {
+				r.report(r.errPreProc(ErrPeerIdle))
+			}
+
+			err := sock.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(r.pongTimeout))
+			if err != nil {
+				// A client which can't be pinged fails to answer in time as well
+				r.report(r.errPreProc(err))
+			}
+		}
+	}()
+}
+
+// extendReadDeadline gives the client of sock until the next ping has timed out to show signs of life.
+func (r *Router) extendReadDeadline(sock *socket) {
+	if r.pingInterval == 0 {
+		return
+	}
+
+	err := sock.conn.SetReadDeadline(time.Now().Add(r.pingInterval + r.pongTimeout))
+	if err != nil {
+		r.report(r.errPreProc(err))
+	}
+}
+
+// isTimeout reports whether or not err is the result of reading past the read deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,106 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newBatchModeRouter returns a router answering batches in mode.
+func newBatchModeRouter(mode wsrpc.BatchMode) *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetBatchMode(mode)
+
+	router.SetHandler("sleep", func(ctx wsrpc.Context) (err error) {
+		var ms int
+		err = json.Unmarshal(ctx.Request().Params, &ms)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		ctx.Response().Result = ctx.Request().Params
+
+		return nil
+	})
+	router.SetHandler("fail", func(ctx wsrpc.Context) error {
+		return errors.New("failed")
+	})
+
+	return router
+}
+
+func TestBatchMode_incremental(t *testing.T) {
+	server := httptest.NewServer(newBatchModeRouter(wsrpc.BatchIncremental))
+	defer server.Close()
+
+	conn, _ := dial(t, server, nil)
+
+	err := conn.WriteJSON([]wsrpc.Request{
+		{Id: 1, Method: "sleep", Type: wsrpc.TypeCall, Params: json.RawMessage(`200`)},
+		{Id: 2, Method: "sleep", Type: wsrpc.TypeCall, Params: json.RawMessage(`0`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+
+	// The fast call is not held up by the slow one
+	for _, id := range []int{2, 1} {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.Id != id {
+			t.Fatalf("expected result of job %d; got %+v", id, res)
+		}
+	}
+}
+
+func TestBatchMode_ordered(t *testing.T) {
+	server := httptest.NewServer(newBatchModeRouter(wsrpc.BatchOrdered))
+	defer server.Close()
+
+	body, _ := json.Marshal([]wsrpc.Request{
+		{Id: 1, Method: "sleep", Type: wsrpc.TypeCall, Params: json.RawMessage(`50`)},
+		{Id: 2, Method: "fail", Type: wsrpc.TypeCall},
+		{Method: "sleep", Type: wsrpc.TypeNotify, Params: json.RawMessage(`0`)},
+		{Id: 3, Method: "sleep", Type: wsrpc.TypeCall, Params: json.RawMessage(`0`)},
+	})
+
+	rsp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to post batch: %v", err)
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	var results []wsrpc.Response
+	err = json.Unmarshal(data, &results)
+	if err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", data, err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected a result per call; got %s", data)
+	}
+	for i, res := range results {
+		if res.Id != i+1 {
+			t.Fatalf("expected results in request order; got %s", data)
+		}
+	}
+	if results[1].Error == nil {
+		t.Fatalf("expected failed call to keep its place; got %s", data)
+	}
+}
@@ -0,0 +1,70 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestBidiStream(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "echo", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeMessage, Params: json.RawMessage(fmt.Sprint(i))})
+		if err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.JobId != jobId || string(res.Result) != fmt.Sprint(i) {
+			t.Fatalf("expected echo of %d; got %+v", i, res)
+		}
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeClose})
+	if err != nil {
+		t.Fatalf("failed to half-close stream: %v", err)
+	}
+
+	var eof wsrpc.Response
+	err = conn.ReadJSON(&eof)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if eof.JobId != jobId || eof.Error == nil || eof.Error.Code != wsrpc.EOF().Code {
+		t.Fatalf("expected EOF after half-close; got %+v", eof)
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeMessage})
+	if err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+
+	var notFound wsrpc.Response
+	err = conn.ReadJSON(&notFound)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found after EOF; got %+v", notFound)
+	}
+}
@@ -0,0 +1,77 @@
+package integration_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestCancel(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read stream response: %v", err)
+	}
+	if res.JobId != jobId || res.Error != nil {
+		t.Fatalf("unexpected stream response: %+v", res)
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel})
+	if err != nil {
+		t.Fatalf("failed to cancel stream: %v", err)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	for {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("no cancel acknowledgement received: %v", err)
+		}
+
+		if res.Error == nil {
+			continue
+		}
+
+		if res.JobId != jobId || res.Error.Code != wsrpc.Cancelled().Code {
+			t.Fatalf("expected cancel acknowledgement; got %+v", res.Error)
+		}
+
+		break
+	}
+
+	unknown := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: unknown, Type: wsrpc.TypeCancel})
+	if err != nil {
+		t.Fatalf("failed to cancel unknown job: %v", err)
+	}
+
+	var notFound wsrpc.Response
+	err = conn.ReadJSON(&notFound)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if notFound.JobId != unknown || notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(unknown).Code {
+		t.Fatalf("expected job not found; got %+v", notFound)
+	}
+}
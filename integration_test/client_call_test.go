@@ -0,0 +1,57 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestClientCall(t *testing.T) {
+	conn, _, err := (&websocket.Dialer{Subprotocols: []string{wsrpc.ProtocolV2JSON.Name}}).Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ask", Type: wsrpc.TypeCall, Params: json.RawMessage(`"px"`)})
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	var call wsrpc.Request
+	err = conn.ReadJSON(&call)
+	if err != nil {
+		t.Fatalf("failed to read server call: %v", err)
+	}
+	if call.Method != "viewport" || call.Type != wsrpc.TypeCall || string(call.Params) != `"px"` {
+		t.Fatalf("unexpected server call: %+v", call)
+	}
+
+	err = conn.WriteJSON(wsrpc.Response{JobId: call.JobId, Result: json.RawMessage(`{"width":1920,"height":1080}`)})
+	if err != nil {
+		t.Fatalf("failed to respond to server call: %v", err)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.JobId != jobId || res.Error != nil {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	var viewport struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+	err = json.Unmarshal(res.Result, &viewport)
+	if err != nil || viewport.Width != 1920 || viewport.Height != 1080 {
+		t.Fatalf("unexpected result: %s", res.Result)
+	}
+}
@@ -0,0 +1,242 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestMessagePackCodec(t *testing.T) {
+	request := map[string]interface{}{
+		"jobId":  uuid.New().String(),
+		"method": "add",
+		"type":   string(wsrpc.TypeCall),
+		"header": map[string]interface{}{"A": 40, "B": 2},
+	}
+	data, err := msgpack.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+
+	var response struct {
+		JobId  string            `msgpack:"jobId"`
+		Result int               `msgpack:"result"`
+		Error  map[string]string `msgpack:"error"`
+	}
+
+	t.Run("webSocket", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl+"/?codec=msgpack", nil)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		err = conn.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if msgType != websocket.BinaryMessage {
+			t.Fatalf("expected a binary frame; got %d", msgType)
+		}
+
+		err = msgpack.Unmarshal(msg, &response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.JobId != request["jobId"] || response.Result != 42 || response.Error != nil {
+			t.Fatalf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("longPoll", func(t *testing.T) {
+		resp, err := http.Post("http://"+serviceUrl, "application/msgpack", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.Header.Get("Content-Type") != "application/msgpack" {
+			t.Fatalf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+		}
+
+		msg, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		err = msgpack.Unmarshal(msg, &response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.JobId != request["jobId"] || response.Result != 42 || response.Error != nil {
+			t.Fatalf("unexpected response: %+v", response)
+		}
+	})
+}
+
+func TestMessagePackCodec_params(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl+"/?codec=msgpack", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Params and results are MessagePack all the way to and from the handler
+	request := map[string]interface{}{
+		"jobId":  uuid.New().String(),
+		"method": "square",
+		"type":   string(wsrpc.TypeCall),
+		"params": map[string]interface{}{"val": 7},
+	}
+	data, err := msgpack.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+
+	err = conn.WriteMessage(websocket.BinaryMessage, data)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	var response struct {
+		JobId  string                 `msgpack:"jobId"`
+		Result float64                `msgpack:"result"`
+		Error  map[string]interface{} `msgpack:"error"`
+	}
+	err = msgpack.Unmarshal(msg, &response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.JobId != request["jobId"] || response.Result != 49 || response.Error != nil {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestMessagePackCodec_json(t *testing.T) {
+	router := wsrpc.NewRouter()
+	router.SetHandler("object", func(ctx wsrpc.Context) (err error) {
+		ctx.Response().Result, err = json.Marshal(map[string]int{"val": 3})
+		return err
+	})
+	// The single byte of the json 3 is also the MessagePack 51
+	router.SetHandler("digit", func(ctx wsrpc.Context) (err error) {
+		ctx.Response().Result, err = json.Marshal(3)
+		return err
+	})
+	router.SetHandler("invalid", func(ctx wsrpc.Context) error {
+		ctx.Response().Result = json.RawMessage(`{"val":`)
+		return nil
+	})
+	router.SetHandler("push", func(ctx wsrpc.Context) (err error) {
+		event := wsrpc.NewEvent("pushed")
+		event.Data, err = json.Marshal([]string{"gopher"})
+		if err != nil {
+			return err
+		}
+
+		return ctx.Conn().Push(event)
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsrpc.ProtocolV2MessagePack.Name}}
+	conn, _, err := dialer.Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	// call calls method and decodes its response into response
+	call := func(method string, response interface{}) {
+		t.Helper()
+
+		data, err := msgpack.Marshal(map[string]interface{}{
+			"jobId":  uuid.New().String(),
+			"method": method,
+			"type":   string(wsrpc.TypeCall),
+		})
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+
+		err = conn.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read response to %s: %v", method, err)
+		}
+
+		err = msgpack.Unmarshal(msg, response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+
+	var object struct {
+		Result struct {
+			Val int `msgpack:"val"`
+		} `msgpack:"result"`
+		Error map[string]interface{} `msgpack:"error"`
+	}
+	call("object", &object)
+	if object.Result.Val != 3 || object.Error != nil {
+		t.Fatalf("expected json result to be converted; got %+v", object)
+	}
+
+	var digit struct {
+		Result int                    `msgpack:"result"`
+		Error  map[string]interface{} `msgpack:"error"`
+	}
+	call("digit", &digit)
+	if digit.Result != 3 || digit.Error != nil {
+		t.Fatalf("expected json digit to be converted; got %+v", digit)
+	}
+
+	// Results which are neither json nor MessagePack are answered with an error rather than dropped
+	var invalid struct {
+		Result interface{}            `msgpack:"result"`
+		Error  map[string]interface{} `msgpack:"error"`
+	}
+	call("invalid", &invalid)
+	if invalid.Result != nil || invalid.Error == nil {
+		t.Fatalf("expected server error; got %+v", invalid)
+	}
+
+	var event struct {
+		Type string   `msgpack:"type"`
+		Data []string `msgpack:"data"`
+	}
+	call("push", &event)
+	if event.Type != string(wsrpc.TypeEvent) || len(event.Data) != 1 || event.Data[0] != "gopher" {
+		t.Fatalf("expected json event data to be converted; got %+v", event)
+	}
+}
@@ -0,0 +1,117 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestFlowControl(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"credits": 2}})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read stream response: %v", err)
+		}
+		if res.JobId != jobId || res.Error != nil {
+			t.Fatalf("unexpected stream response: %+v", res)
+		}
+	}
+
+	// The ticker has run out of credits, so the next message has to be the answer to the call
+	time.Sleep(100 * time.Millisecond)
+
+	callId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: callId, Method: "square", Type: wsrpc.TypeCall, Params: json.RawMessage(`{"val": 3}`)})
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	var call wsrpc.Response
+	err = conn.ReadJSON(&call)
+	if err != nil {
+		t.Fatalf("failed to read call response: %v", err)
+	}
+	if call.JobId != callId {
+		t.Fatalf("expected stream to be held back until granted credits; got %+v", call)
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCredit, Params: json.RawMessage(`1`)})
+	if err != nil {
+		t.Fatalf("failed to grant credits: %v", err)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read stream response: %v", err)
+	}
+	if res.JobId != jobId || res.Error != nil || string(res.Result) != "2" {
+		t.Fatalf("expected third tick after granting credits; got %+v", res)
+	}
+
+	// A stream waiting for credits can still be cancelled
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel})
+	if err != nil {
+		t.Fatalf("failed to cancel stream: %v", err)
+	}
+
+	var ack wsrpc.Response
+	err = conn.ReadJSON(&ack)
+	if err != nil {
+		t.Fatalf("no cancel acknowledgement received: %v", err)
+	}
+	if ack.JobId != jobId || ack.Error == nil || ack.Error.Code != wsrpc.Cancelled().Code {
+		t.Fatalf("expected cancel acknowledgement; got %+v", ack)
+	}
+}
+
+func TestFlowControl_NotControlled(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "echo", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCredit, Params: json.RawMessage(`1`)})
+	if err != nil {
+		t.Fatalf("failed to grant credits: %v", err)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.JobId != jobId || res.Error == nil || res.Error.Code != wsrpc.InvalidRequestError("").Code {
+		t.Fatalf("expected invalid request error; got %+v", res)
+	}
+}
@@ -0,0 +1,91 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/modfin/wsrpc"
+)
+
+// trace returns middleware appending name to the trace header of the request.
+func trace(name string) wsrpc.Middleware {
+	return func(c wsrpc.Context, next wsrpc.NextFunc) error {
+		c.Request().Header.Set("trace", c.Request().Header.Get("trace").StringOr("")+name+" ")
+		return next(c)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	router := wsrpc.NewRouter()
+	router.SetErrorPostProc(func(err error) {})
+	router.Use(trace("router"))
+
+	billing := router.Group("billing.", trace("billing"), func(c wsrpc.Context, next wsrpc.NextFunc) error {
+		if c.Request().Header.Get("role").StringOr("") != "billing" {
+			return errors.New("not allowed")
+		}
+
+		return next(c)
+	})
+	invoices := billing.Group("invoices.", trace("invoices"))
+
+	traced := func(ctx wsrpc.Context) (err error) {
+		ctx.Response().Result, err = json.Marshal(ctx.Request().Header.Get("trace").StringOr(""))
+		return err
+	}
+	invoices.SetHandler("list", traced, trace("list"))
+	invoices.SetStream("watch", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) error {
+		return traced(ctx)
+	})
+	billing.Use(trace("late"))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		req    wsrpc.Request
+		result string
+		code   int
+	}{
+		{name: "call", req: wsrpc.Request{Method: "billing.invoices.list", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"role": "billing"}}, result: `"router billing late invoices list "`},
+		{name: "stream", req: wsrpc.Request{Method: "billing.invoices.watch", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"role": "billing"}}, result: `"router billing late invoices "`},
+		{name: "group middleware", req: wsrpc.Request{Method: "billing.invoices.list", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"role": "support"}}, code: wsrpc.ServerError(errors.New("")).Code},
+		{name: "unprefixed", req: wsrpc.Request{Method: "list", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"role": "billing"}}, code: wsrpc.MethodNotFoundError("").Code},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.Id = 1
+			data, _ := json.Marshal(tc.req)
+
+			resp, err := http.Post(server.URL, "application/json", bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to post: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var res wsrpc.Response
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if tc.code != 0 {
+				if res.Error == nil || res.Error.Code != tc.code {
+					t.Fatalf("expected error code %d; got %+v", tc.code, res)
+				}
+
+				return
+			}
+
+			if res.Error != nil || string(res.Result) != tc.result {
+				t.Fatalf("expected result %s; got %+v", tc.result, res)
+			}
+		})
+	}
+}
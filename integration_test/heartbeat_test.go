@@ -0,0 +1,117 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newHeartbeatRouter returns a router pinging its clients, passing the causes of their disconnects on to disconnects
+// and the idle pings it reports on to idles.
+func newHeartbeatRouter(disconnects, idles chan error) *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetHeartbeat(50*time.Millisecond, 80*time.Millisecond)
+	router.SetErrorPostProc(func(err error) {
+		if errors.Is(err, wsrpc.ErrPeerIdle) {
+			select {
+			case idles <- err:
+			default:
+			}
+		}
+	})
+	router.SetDisconnectHook(func(conn *wsrpc.Conn) {
+		disconnects <- context.Cause(conn.Context())
+	})
+
+	return router
+}
+
+func TestHeartbeat(t *testing.T) {
+	disconnects, idles := make(chan error, 10), make(chan error, 10)
+	server := httptest.NewServer(newHeartbeatRouter(disconnects, idles))
+	defer server.Close()
+
+	t.Run("alive", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		var pings int
+		conn.SetPingHandler(func(data string) error {
+			pings++
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+
+		// Reading lets the client answer pings
+		err := conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+		if err != nil {
+			t.Fatalf("failed to set read deadline: %v", err)
+		}
+
+		_, _, err = conn.ReadMessage()
+		if err == nil || websocket.IsUnexpectedCloseError(err) {
+			t.Fatalf("expected the connection to stay open; got %v", err)
+		}
+		if pings < 3 {
+			t.Fatalf("expected pings; got %d", pings)
+		}
+
+		select {
+		case cause := <-disconnects:
+			t.Fatalf("expected the connection to stay open; got disconnect %v", cause)
+		case err := <-idles:
+			t.Fatalf("expected the connection not to be idle; got %v", err)
+		default:
+		}
+
+		conn.Close()
+		select {
+		case <-disconnects:
+		case <-time.After(time.Second):
+			t.Fatalf("expected a disconnect")
+		}
+	})
+
+	t.Run("dead", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		// A client which never answers pings
+		conn.SetPingHandler(func(string) error { return nil })
+
+		start := time.Now()
+		_, _, err := conn.ReadMessage()
+		if err == nil || time.Since(start) > 500*time.Millisecond {
+			t.Fatalf("expected the connection to be closed; got %v after %v", err, time.Since(start))
+		}
+
+		select {
+		case cause := <-disconnects:
+			if cause != wsrpc.ErrPeerUnresponsive {
+				t.Fatalf("expected %v; got %v", wsrpc.ErrPeerUnresponsive, cause)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a disconnect")
+		}
+
+		// The missed ping was reported before the connection was torn down
+		select {
+		case <-idles:
+		default:
+			t.Fatalf("expected the client to be reported as idle")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected a heartbeat without timeout to be rejected")
+			}
+		}()
+
+		wsrpc.NewRouter().SetHeartbeat(time.Second, 0)
+	})
+}
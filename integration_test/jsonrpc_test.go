@@ -0,0 +1,235 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newJSONRPCRouter returns a router speaking JSON-RPC 2.0.
+func newJSONRPCRouter() *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetJSONRPC(true)
+	router.SetREST("/rpc/")
+
+	router.SetHandler("subtract", func(ctx wsrpc.Context) (err error) {
+		var params []int
+		err = json.Unmarshal(ctx.Request().Params, &params)
+		if err != nil || len(params) != 2 {
+			var named struct {
+				Minuend    int `json:"minuend"`
+				Subtrahend int `json:"subtrahend"`
+			}
+			err = json.Unmarshal(ctx.Request().Params, &named)
+			if err != nil {
+				return err
+			}
+			params = []int{named.Minuend, named.Subtrahend}
+		}
+
+		ctx.Response().Result, err = json.Marshal(params[0] - params[1])
+
+		return err
+	})
+	router.SetHandler("update", func(ctx wsrpc.Context) error {
+		return nil
+	})
+	router.SetHandler("fail", func(ctx wsrpc.Context) error {
+		return errors.New("failed")
+	})
+
+	return router
+}
+
+func TestJSONRPC(t *testing.T) {
+	server := httptest.NewServer(newJSONRPCRouter())
+	defer server.Close()
+
+	tt := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		{
+			name:     "positional params",
+			request:  `{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": 1}`,
+			expected: `{"jsonrpc": "2.0", "result": 19, "id": 1}`,
+		},
+		{
+			name:     "named params and string id",
+			request:  `{"jsonrpc": "2.0", "method": "subtract", "params": {"subtrahend": 23, "minuend": 42}, "id": "abc"}`,
+			expected: `{"jsonrpc": "2.0", "result": 19, "id": "abc"}`,
+		},
+		{
+			name:     "null id",
+			request:  `{"jsonrpc": "2.0", "method": "update", "id": null}`,
+			expected: `{"jsonrpc": "2.0", "result": null, "id": null}`,
+		},
+		{
+			name:     "notification",
+			request:  `{"jsonrpc": "2.0", "method": "update", "params": [1,2,3,4,5]}`,
+			expected: ``,
+		},
+		{
+			name:     "non existent method",
+			request:  `{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`,
+			expected: `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "method not found: foobar", "data": null}, "id": "1"}`,
+		},
+		{
+			name:     "invalid json",
+			request:  `{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			expected: `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "parse error", "data": null}, "id": null}`,
+		},
+		{
+			name:     "invalid request object",
+			request:  `{"jsonrpc": "2.0", "method": 1, "params": "bar"}`,
+			expected: `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request: method must be a string", "data": null}, "id": null}`,
+		},
+		{
+			name:     "empty batch",
+			request:  `[]`,
+			expected: `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request: empty batch", "data": null}, "id": null}`,
+		},
+		{
+			name:    "invalid batch",
+			request: `[1,2]`,
+			expected: `[
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request: request must be an object", "data": null}, "id": null},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request: request must be an object", "data": null}, "id": null}
+			]`,
+		},
+		{
+			name: "batch of notifications",
+			request: `[
+				{"jsonrpc": "2.0", "method": "update", "params": [1,2,4]},
+				{"jsonrpc": "2.0", "method": "fail"}
+			]`,
+			expected: ``,
+		},
+		{
+			name: "batch",
+			request: `[
+				{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": "1"},
+				{"jsonrpc": "2.0", "method": "update", "params": [7]},
+				{"foo": "boo"},
+				{"jsonrpc": "2.0", "method": "fail", "id": 5}
+			]`,
+			expected: `[
+				{"jsonrpc": "2.0", "result": 19, "id": "1"},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request: jsonrpc must be exactly \"2.0\"", "data": null}, "id": null},
+				{"jsonrpc": "2.0", "error": {"code": -32000, "message": "server error: failed", "data": {}}, "id": 5}
+			]`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL, "application/json", bytes.NewBufferString(tc.request))
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			if !equalJSON(body, []byte(tc.expected)) {
+				t.Errorf("expected %s; got %s", tc.expected, body)
+			}
+		})
+	}
+
+	t.Run("webSocket", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc": "2.0", "method": "subtract", "params": [23, 42], "id": 2}`))
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		expected := `{"jsonrpc": "2.0", "result": -19, "id": 2}`
+		if !equalJSON(body, []byte(expected)) {
+			t.Errorf("expected %s; got %s", expected, body)
+		}
+	})
+}
+
+func TestJSONRPC_REST(t *testing.T) {
+	server := httptest.NewServer(newJSONRPCRouter())
+	defer server.Close()
+
+	// The HTTP facade keeps serving plain calls while the router speaks JSON-RPC 2.0
+	resp, err := http.Post(server.URL+"/rpc/subtract", "application/json", bytes.NewBufferString(`[42, 23]`))
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(body) != "19" {
+		t.Fatalf("expected the result as body; got %d %s", resp.StatusCode, body)
+	}
+}
+
+// equalJSON compares two json documents, the elements of arrays may come in any order.
+func equalJSON(a, b []byte) bool {
+	if len(bytes.TrimSpace(a)) == 0 || len(bytes.TrimSpace(b)) == 0 {
+		return len(bytes.TrimSpace(a)) == len(bytes.TrimSpace(b))
+	}
+
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+
+	la, isSliceA := va.([]interface{})
+	lb, isSliceB := vb.([]interface{})
+	if isSliceA != isSliceB {
+		return false
+	}
+	if !isSliceA {
+		return canonicalJSON(va) == canonicalJSON(vb)
+	}
+	if len(la) != len(lb) {
+		return false
+	}
+
+	remaining := make(map[string]int)
+	for _, v := range la {
+		remaining[canonicalJSON(v)]++
+	}
+	for _, v := range lb {
+		remaining[canonicalJSON(v)]--
+	}
+	for _, n := range remaining {
+		if n != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func canonicalJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
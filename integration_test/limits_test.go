@@ -0,0 +1,151 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newLimitsRouter returns a router capping the resources of its clients.
+func newLimitsRouter() *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetLimits(wsrpc.Limits{
+		MaxMessageSize: 512,
+		MaxBatchSize:   2,
+		MaxJobs:        2,
+		MaxStreams:     1,
+	})
+
+	router.SetHandler("echo", echo)
+	router.SetStream("block", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) error {
+		rsp := ctx.NewResponse()
+		rsp.Result = json.RawMessage(`"started"`)
+
+		err := ch.Write(rsp)
+		if err != nil {
+			return err
+		}
+
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	return router
+}
+
+func TestLimits_messageSize(t *testing.T) {
+	server := httptest.NewServer(newLimitsRouter())
+	defer server.Close()
+
+	large := wsrpc.Request{Id: 1, Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`"` + strings.Repeat("a", 1024) + `"`)}
+
+	t.Run("web socket", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		err := conn.WriteJSON(large)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Fatalf("expected the connection to be closed as the message is too big; got %v", err)
+		}
+	})
+
+	t.Run("long poll", func(t *testing.T) {
+		data, _ := json.Marshal(large)
+
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to post: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status %d; got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+		}
+	})
+}
+
+func TestLimits_batchSize(t *testing.T) {
+	server := httptest.NewServer(newLimitsRouter())
+	defer server.Close()
+
+	data, _ := json.Marshal([]wsrpc.Request{
+		{Id: 1, Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)},
+		{Id: 2, Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`2`)},
+		{Id: 3, Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`3`)},
+	})
+
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var results []wsrpc.Response
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected every request to be answered; got %+v", results)
+	}
+	for _, res := range results {
+		if res.Error == nil || res.Error.Code != wsrpc.LimitExceededError("").Code {
+			t.Fatalf("expected limit error; got %+v", res)
+		}
+	}
+}
+
+func TestLimits_concurrentJobs(t *testing.T) {
+	server := httptest.NewServer(newLimitsRouter())
+	defer server.Close()
+
+	conn, _ := dial(t, server, nil)
+
+	first := uuid.New()
+	res := send(t, conn, wsrpc.Request{JobId: first, Method: "block", Type: wsrpc.TypeStream})
+	if res.Error != nil {
+		t.Fatalf("expected the first stream to start; got %+v", res.Error)
+	}
+
+	res = send(t, conn, wsrpc.Request{Method: "block", Type: wsrpc.TypeStream})
+	if res.Error == nil || res.Error.Code != wsrpc.LimitExceededError("").Code {
+		t.Fatalf("expected the second stream to exceed the limit; got %+v", res)
+	}
+
+	res = send(t, conn, wsrpc.Request{Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)})
+	if res.Error != nil || string(res.Result) != "1" {
+		t.Fatalf("expected calls to run beside the stream; got %+v", res)
+	}
+
+	res = send(t, conn, wsrpc.Request{JobId: first, Type: wsrpc.TypeCancel})
+	if res.Error == nil || res.Error.Code != wsrpc.Cancelled().Code {
+		t.Fatalf("expected cancel acknowledgement; got %+v", res)
+	}
+
+	// The slot of a stream is freed once its handler has returned
+	for i := 0; ; i++ {
+		res = send(t, conn, wsrpc.Request{Method: "block", Type: wsrpc.TypeStream})
+		if res.Error == nil {
+			break
+		}
+		if i == 10 {
+			t.Fatalf("expected a stream to start after the first one ended; got %+v", res)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,203 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newLongPollStreamRouter returns a router serving long poll streams, counting the starts of its count stream in starts.
+func newLongPollStreamRouter(starts *int32) *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetLongPollStreams(time.Second, time.Second)
+
+	router.SetStream("count", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) (err error) {
+		atomic.AddInt32(starts, 1)
+
+		for i := 0; i < 5; i++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			rsp := ctx.NewResponse()
+			rsp.Result, err = json.Marshal(i)
+			if err != nil {
+				return err
+			}
+
+			err = ch.Write(rsp)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	router.SetStream("tick", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) (err error) {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			err = ch.Write(ctx.NewResponse())
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	return router
+}
+
+func TestLongPollStream(t *testing.T) {
+	var starts int32
+	server := httptest.NewServer(newLongPollStreamRouter(&starts))
+	defer server.Close()
+
+	jobId := uuid.New()
+	req := wsrpc.Request{JobId: jobId, Method: "count", Type: wsrpc.TypeStream}
+
+	var results []int
+	var seq uint64
+	header := wsrpc.Headers{}
+	for polls := 0; ; polls++ {
+		if polls > 20 {
+			t.Fatalf("stream did not end; got %v", results)
+		}
+
+		status, data := longPoll(t, server, req)
+		if status == http.StatusNoContent {
+			continue
+		}
+
+		var responses []wsrpc.Response
+		err := json.Unmarshal(data, &responses)
+		if err != nil {
+			t.Fatalf("failed to unmarshal poll %s: %v", data, err)
+		}
+
+		eof := false
+		for _, res := range responses {
+			if res.JobId != jobId || res.Seq != seq+1 {
+				t.Fatalf("expected response with seq %d; got %+v", seq+1, res)
+			}
+			seq = res.Seq
+			if seq == 1 {
+				header.Set("resumeToken", res.Header.Get("resumeToken").StringOr(""))
+			}
+
+			if res.Error != nil {
+				eof = res.Error.Code == wsrpc.EOF().Code
+				continue
+			}
+
+			var i int
+			err = json.Unmarshal(res.Result, &i)
+			if err != nil {
+				t.Fatalf("failed to unmarshal result: %v", err)
+			}
+			results = append(results, i)
+		}
+		if eof {
+			break
+		}
+
+		req = wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(strconv.FormatUint(seq, 10)), Header: header}
+	}
+
+	for i := range results {
+		if results[i] != i {
+			t.Fatalf("expected results 0 through 4 in order; got %v", results)
+		}
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results; got %v", results)
+	}
+	if starts := atomic.LoadInt32(&starts); starts != 1 {
+		t.Fatalf("expected handler to run once across polls; ran %d times", starts)
+	}
+
+	// Streams are forgotten once their last response has been polled
+	_, data := longPoll(t, server, wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(strconv.FormatUint(seq, 10)), Header: header})
+
+	var notFound wsrpc.Response
+	err := json.Unmarshal(data, &notFound)
+	if err != nil || notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found after the stream ended; got %s", data)
+	}
+}
+
+func TestLongPollStream_cancel(t *testing.T) {
+	var starts int32
+	server := httptest.NewServer(newLongPollStreamRouter(&starts))
+	defer server.Close()
+
+	jobId := uuid.New()
+	_, data := longPoll(t, server, wsrpc.Request{JobId: jobId, Method: "tick", Type: wsrpc.TypeStream})
+
+	var responses []wsrpc.Response
+	err := json.Unmarshal(data, &responses)
+	if err != nil || len(responses) == 0 {
+		t.Fatalf("expected the first responses of the stream; got %s", data)
+	}
+	header := wsrpc.Headers{}
+	header.Set("resumeToken", responses[0].Header.Get("resumeToken").StringOr(""))
+
+	// Control requests need the token as well
+	_, data = longPoll(t, server, wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel})
+
+	var notFound wsrpc.Response
+	err = json.Unmarshal(data, &notFound)
+	if err != nil || notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found without the token; got %s", data)
+	}
+
+	status, data := longPoll(t, server, wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel, Header: header})
+	if status != http.StatusNoContent {
+		t.Fatalf("expected the cancellation to be answered with no content; got %d %s", status, data)
+	}
+
+	// The cancellation is acknowledged through the next poll
+	seq := responses[len(responses)-1].Seq
+	for polls := 0; ; polls++ {
+		if polls > 20 {
+			t.Fatalf("no cancel acknowledgement received")
+		}
+
+		status, data = longPoll(t, server, wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(strconv.FormatUint(seq, 10)), Header: header})
+		if status == http.StatusNoContent {
+			continue
+		}
+
+		responses = nil
+		err = json.Unmarshal(data, &responses)
+		if err != nil {
+			t.Fatalf("failed to unmarshal poll %s: %v", data, err)
+		}
+
+		last := responses[len(responses)-1]
+		seq = last.Seq
+		if last.Error != nil {
+			if last.Error.Code != wsrpc.Cancelled().Code {
+				t.Fatalf("expected cancel acknowledgement; got %+v", last.Error)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestMixedBatch(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	callId, missingId, streamId := uuid.New(), uuid.New(), uuid.New()
+	err = conn.WriteJSON([]wsrpc.Request{
+		{JobId: callId, Method: "square", Type: wsrpc.TypeCall, Params: json.RawMessage(`{"val": 3}`)},
+		{JobId: missingId, Method: "missing", Type: wsrpc.TypeCall},
+		{JobId: streamId, Method: "countdown", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"state": 2}},
+		{Method: "square", Type: wsrpc.TypeNotify, Params: json.RawMessage(`{"val": 1}`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+
+	responses := make(map[uuid.UUID][]wsrpc.Response)
+	for eof := false; !eof || len(responses[callId]) == 0 || len(responses[missingId]) == 0; {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v; got %+v", err, responses)
+		}
+
+		responses[res.JobId] = append(responses[res.JobId], res)
+		if res.JobId == streamId && res.Error != nil && res.Error.Code == wsrpc.EOF().Code {
+			eof = true
+		}
+	}
+
+	if len(responses) != 3 {
+		t.Fatalf("expected responses for the calls and the stream only; got %+v", responses)
+	}
+	if call := responses[callId]; len(call) != 1 || string(call[0].Result) != "9" {
+		t.Fatalf("expected one call result; got %+v", call)
+	}
+	if missing := responses[missingId]; len(missing) != 1 || missing[0].Error == nil || missing[0].Error.Code != wsrpc.MethodNotFoundError("missing").Code {
+		t.Fatalf("expected method not found; got %+v", missing)
+	}
+	if stream := responses[streamId]; len(stream) != 3 || string(stream[0].Result) != "2" || string(stream[1].Result) != "1" {
+		t.Fatalf("expected stream to count down; got %+v", stream)
+	}
+}
@@ -0,0 +1,83 @@
+package integration_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/modfin/wsrpc"
+)
+
+func postNDJSON(t *testing.T, body interface{}) []wsrpc.Response {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal body: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+serviceUrl, bytes.NewReader(data))
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	defer rsp.Body.Close()
+
+	if ct := rsp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("expected newline delimited json; got %s", ct)
+	}
+
+	var lines []wsrpc.Response
+	scanner := bufio.NewScanner(rsp.Body)
+	for scanner.Scan() {
+		var res wsrpc.Response
+		err = json.Unmarshal(scanner.Bytes(), &res)
+		if err != nil {
+			t.Fatalf("failed to unmarshal line %s: %v", scanner.Bytes(), err)
+		}
+		lines = append(lines, res)
+	}
+
+	return lines
+}
+
+func TestNDJSON_stream(t *testing.T) {
+	lines := postNDJSON(t, wsrpc.Request{Id: 1, Method: "countdown", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"state": 3}})
+
+	if len(lines) != 4 {
+		t.Fatalf("expected 3 responses and EOF; got %+v", lines)
+	}
+	for i, res := range lines[:3] {
+		if string(res.Result) != []string{"3", "2", "1"}[i] {
+			t.Fatalf("unexpected response %d: %+v", i, res)
+		}
+	}
+	if lines[3].Error == nil || lines[3].Error.Code != wsrpc.EOF().Code {
+		t.Fatalf("expected EOF; got %+v", lines[3])
+	}
+}
+
+func TestNDJSON_batch(t *testing.T) {
+	lines := postNDJSON(t, []wsrpc.Request{
+		{Id: 1, Method: "add", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"A": 1, "B": 2}},
+		{Id: 2, Method: "add", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"A": 3, "B": 4}},
+		{Id: 3, Method: "missing", Type: wsrpc.TypeCall},
+	})
+
+	if len(lines) != 3 {
+		t.Fatalf("expected a line per job; got %+v", lines)
+	}
+
+	results := make(map[int]wsrpc.Response)
+	for _, res := range lines {
+		results[res.Id] = res
+	}
+	if string(results[1].Result) != "3" || string(results[2].Result) != "7" {
+		t.Fatalf("unexpected results: %+v", lines)
+	}
+	if results[3].Error == nil || results[3].Error.Code != wsrpc.MethodNotFoundError("missing").Code {
+		t.Fatalf("expected method not found; got %+v", results[3])
+	}
+}
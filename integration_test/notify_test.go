@@ -0,0 +1,63 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestNotify(t *testing.T) {
+	notification := wsrpc.Request{Method: "add", Type: wsrpc.TypeNotify, Header: wsrpc.NewHeader()}
+	notification.Header.Set("A", 1)
+
+	t.Run("webSocket", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		err = conn.WriteJSON(notification)
+		if err != nil {
+			t.Fatalf("failed to send notification: %v", err)
+		}
+
+		jobId := uuid.New()
+		err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "add", Type: wsrpc.TypeCall})
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.JobId != jobId {
+			t.Fatalf("expected only the call to be answered; got %+v", res)
+		}
+	})
+
+	t.Run("longPoll", func(t *testing.T) {
+		data, err := json.Marshal(&notification)
+		if err != nil {
+			t.Fatalf("failed to encode notification: %v", err)
+		}
+
+		resp, err := http.Post("http://"+serviceUrl, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to send notification: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected status %d; got %d", http.StatusNoContent, resp.StatusCode)
+		}
+	})
+}
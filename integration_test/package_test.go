@@ -1,14 +1,22 @@
 package integration_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
 	"github.com/modfin/wsrpc"
 )
 
@@ -45,6 +53,84 @@ func shutdown() {
 
 }
 
+// wsURL returns the web socket URL of server.
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dial connects a web socket client to server, it is closed once the test is done.
+// Reads fail after a few seconds so that a missing response fails the test rather than hanging it.
+func dial(t *testing.T, server *httptest.Server, header http.Header) (*websocket.Conn, *http.Response) {
+	t.Helper()
+
+	conn, rsp, err := websocket.DefaultDialer.Dial(wsURL(server), header)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	err = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	return conn, rsp
+}
+
+// send sends req over conn and returns the first response to its job, responses to other jobs are skipped.
+// Requests without a job id are given one.
+func send(t *testing.T, conn *websocket.Conn, req wsrpc.Request) wsrpc.Response {
+	t.Helper()
+
+	if req.JobId == uuid.Nil {
+		req.JobId = uuid.New()
+	}
+
+	err := conn.WriteJSON(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	for {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		if res.JobId == req.JobId {
+			return res
+		}
+	}
+}
+
+// longPoll posts req to server and returns the status and body of its answer.
+func longPoll(t *testing.T, server *httptest.Server, req wsrpc.Request) (int, []byte) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %v", err)
+	}
+
+	rsp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to long poll: %v", err)
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatalf("failed to read long poll response: %v", err)
+	}
+
+	return rsp.StatusCode, data
+}
+
+// echo answers with the params of its request.
+func echo(ctx wsrpc.Context) error {
+	ctx.Response().Result = ctx.Request().Params
+	return nil
+}
+
 var tests = struct {
 	add       []addTest
 	square    []squareTest
@@ -0,0 +1,73 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	tt := []struct {
+		name         string
+		subprotocols []string
+		expected     string
+		pushError    bool
+	}{
+		{name: "no subprotocol", subprotocols: nil, expected: wsrpc.ProtocolV1.Name, pushError: true},
+		{name: "v1", subprotocols: []string{wsrpc.ProtocolV1.Name}, expected: wsrpc.ProtocolV1.Name, pushError: true},
+		{name: "v2", subprotocols: []string{wsrpc.ProtocolV2JSON.Name}, expected: wsrpc.ProtocolV2JSON.Name},
+		{name: "unknown and v2", subprotocols: []string{"wsrpc.v9+xml", wsrpc.ProtocolV2JSON.Name}, expected: wsrpc.ProtocolV2JSON.Name},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tc.subprotocols}
+			conn, _, err := dialer.Dial("ws://"+serviceUrl, nil)
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+
+			if len(tc.subprotocols) > 0 && conn.Subprotocol() != tc.expected {
+				t.Fatalf("negotiated %q; expected %q", conn.Subprotocol(), tc.expected)
+			}
+
+			err = conn.WriteJSON(wsrpc.Request{JobId: uuid.New(), Method: "protocol", Type: wsrpc.TypeCall})
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+
+			var res wsrpc.Response
+			err = conn.ReadJSON(&res)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			var name string
+			err = json.Unmarshal(res.Result, &name)
+			if err != nil || name != tc.expected {
+				t.Fatalf("handler saw protocol %q; expected %q", name, tc.expected)
+			}
+
+			err = conn.WriteJSON(wsrpc.Request{JobId: uuid.New(), Method: "notify", Type: wsrpc.TypeCall})
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+
+			var msg map[string]interface{}
+			err = conn.ReadJSON(&msg)
+			if err != nil {
+				t.Fatalf("failed to read message: %v", err)
+			}
+
+			_, isEvent := msg["event"]
+			if isEvent == tc.pushError {
+				t.Fatalf("unexpected push behaviour: %+v", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestPush(t *testing.T) {
+	conn, _, err := (&websocket.Dialer{Subprotocols: []string{wsrpc.ProtocolV2JSON.Name}}).Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "notify", Type: wsrpc.TypeCall, Params: json.RawMessage(`"hello"`)})
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	var event wsrpc.Event
+	err = conn.ReadJSON(&event)
+	if err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	if event.Type != wsrpc.TypeEvent || event.Event != "notified" || string(event.Data) != `"hello"` {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.JobId != jobId || res.Error != nil {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	var conns int
+	for _, c := range router.Conns() {
+		if c.Context().Err() == nil {
+			conns++
+		}
+	}
+	if conns == 0 {
+		t.Fatalf("expected the connection to be registered on the router")
+	}
+}
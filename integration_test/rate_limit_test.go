@@ -0,0 +1,115 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newRateLimitRouter returns a router limiting the rate of its clients, and of its expensive method by IP address.
+func newRateLimitRouter() *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.Use(wsrpc.RateLimit(3, time.Second, wsrpc.ByClient))
+
+	router.SetHandler("echo", echo)
+	router.SetHandler("expensive", func(ctx wsrpc.Context) error {
+		ctx.Response().Result = json.RawMessage(`"done"`)
+		return nil
+	}, wsrpc.RateLimit(1, time.Second, wsrpc.ByRemoteIP))
+	router.SetStream("once", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) error {
+		ctx.Response().Result = json.RawMessage(`"done"`)
+		return nil
+	})
+
+	return router
+}
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(newRateLimitRouter())
+	defer server.Close()
+
+	assertLimited := func(res wsrpc.Response) {
+		t.Helper()
+
+		if res.Error == nil || res.Error.Code != wsrpc.RateLimitedError().Code {
+			t.Fatalf("expected rate limited error; got %+v", res)
+		}
+
+		retryAfter := res.Header.Get("retryAfter").IntOr(0)
+		if retryAfter < 1 || retryAfter > 1000 {
+			t.Fatalf("expected retryAfter hint; got %+v", res.Header)
+		}
+	}
+
+	t.Run("router", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		for i := 0; i < 3; i++ {
+			res := send(t, conn, wsrpc.Request{Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)})
+			if res.Error != nil {
+				t.Fatalf("expected job %d to be allowed; got %+v", i, res.Error)
+			}
+		}
+
+		assertLimited(send(t, conn, wsrpc.Request{Method: "echo", Type: wsrpc.TypeCall}))
+
+		// Rejected streams end with their error
+		assertLimited(send(t, conn, wsrpc.Request{Method: "once", Type: wsrpc.TypeStream}))
+
+		// Every connection has a rate of its own
+		other, _ := dial(t, server, nil)
+
+		res := send(t, other, wsrpc.Request{Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)})
+		if res.Error != nil {
+			t.Fatalf("expected jobs of another connection to be allowed; got %+v", res.Error)
+		}
+	})
+
+	t.Run("long poll", func(t *testing.T) {
+		poll := func() wsrpc.Response {
+			t.Helper()
+
+			_, data := longPoll(t, server, wsrpc.Request{Id: 1, Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)})
+
+			var res wsrpc.Response
+			err := json.Unmarshal(data, &res)
+			if err != nil {
+				t.Fatalf("failed to read response %s: %v", data, err)
+			}
+
+			return res
+		}
+
+		// Every request is a connection of its own, the client is limited by its address instead
+		for i := 0; i < 3; i++ {
+			res := poll()
+			if res.Error != nil {
+				t.Fatalf("expected job %d to be allowed; got %+v", i, res.Error)
+			}
+		}
+
+		assertLimited(poll())
+	})
+
+	t.Run("method", func(t *testing.T) {
+		conn, _ := dial(t, server, nil)
+
+		res := send(t, conn, wsrpc.Request{Method: "expensive", Type: wsrpc.TypeCall})
+		if res.Error != nil {
+			t.Fatalf("expected first job to be allowed; got %+v", res.Error)
+		}
+
+		// The method is limited by remote IP rather than connection
+		other, _ := dial(t, server, nil)
+
+		assertLimited(send(t, other, wsrpc.Request{Method: "expensive", Type: wsrpc.TypeCall}))
+
+		res = send(t, other, wsrpc.Request{Method: "echo", Type: wsrpc.TypeCall, Params: json.RawMessage(`1`)})
+		if res.Error != nil {
+			t.Fatalf("expected other methods to be allowed; got %+v", res.Error)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestREST(t *testing.T) {
+	tt := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		header   http.Header
+		status   int
+		expected string
+		// rspHeader holds the expected response headers
+		rspHeader http.Header
+	}{
+		{name: "post params", method: http.MethodPost, path: "/rpc/square", body: `{"val": 3}`, status: http.StatusOK, expected: "9"},
+		{name: "query params", method: http.MethodGet, path: "/rpc/square?val=4", status: http.StatusOK, expected: "16"},
+		{name: "headers", method: http.MethodPost, path: "/rpc/add", header: http.Header{"A": {"1"}, "B": {"2"}}, status: http.StatusOK, expected: "3", rspHeader: http.Header{"Terms": {"[1,2]"}}},
+		{name: "method not found", method: http.MethodPost, path: "/rpc/missing", status: http.StatusNotFound},
+		{name: "streams are not callable", method: http.MethodPost, path: "/rpc/countdown", status: http.StatusNotFound},
+		{name: "invalid params", method: http.MethodPost, path: "/rpc/square", body: `{"val":`, status: http.StatusBadRequest},
+		{name: "handler error", method: http.MethodPost, path: "/rpc/square", body: `"three"`, status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, "http://"+serviceUrl+tc.path, bytes.NewReader([]byte(tc.body)))
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			rsp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to request: %v", err)
+			}
+			defer rsp.Body.Close()
+
+			body, err := ioutil.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			if rsp.StatusCode != tc.status {
+				t.Fatalf("expected status %d; got %d: %s", tc.status, rsp.StatusCode, body)
+			}
+
+			if tc.status != http.StatusOK {
+				var rspErr wsrpc.Error
+				err = json.Unmarshal(body, &rspErr)
+				if err != nil || rspErr.Code == 0 {
+					t.Fatalf("expected error as body; got %s", body)
+				}
+
+				return
+			}
+
+			if string(body) != tc.expected {
+				t.Fatalf("expected %s; got %s", tc.expected, body)
+			}
+
+			for key := range tc.rspHeader {
+				if rsp.Header.Get(key) != tc.rspHeader.Get(key) {
+					t.Fatalf("expected header %s to be %s; got %s", key, tc.rspHeader.Get(key), rsp.Header.Get(key))
+				}
+			}
+		})
+	}
+}
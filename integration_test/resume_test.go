@@ -0,0 +1,258 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+func TestResume(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	var last wsrpc.Response
+	var token string
+	for i := 1; i <= 3; i++ {
+		err = conn.ReadJSON(&last)
+		if err != nil {
+			t.Fatalf("failed to read stream response: %v", err)
+		}
+		if last.JobId != jobId || last.Seq != uint64(i) {
+			t.Fatalf("expected response with seq %d; got %+v", i, last)
+		}
+		if i == 1 {
+			token = last.Header.Get("resumeToken").StringOr("")
+		}
+	}
+	conn.Close()
+
+	if token == "" {
+		t.Fatalf("expected the first response to carry a resume token")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	conn, _, err = websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	// Streams can not be resumed without their token
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`3`)})
+	if err != nil {
+		t.Fatalf("failed to resume stream: %v", err)
+	}
+
+	var notFound wsrpc.Response
+	err = conn.ReadJSON(&notFound)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found without a resume token; got %+v", notFound)
+	}
+
+	// Nor can their JobId be taken over by another stream
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	var inUse wsrpc.Response
+	err = conn.ReadJSON(&inUse)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if inUse.Error == nil || inUse.Error.Code != wsrpc.InvalidRequestError("").Code {
+		t.Fatalf("expected jobId in use error; got %+v", inUse)
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`3`), Header: wsrpc.Headers{"resumeToken": token}})
+	if err != nil {
+		t.Fatalf("failed to resume stream: %v", err)
+	}
+
+	// The stream continues where the client left off, without gaps
+	for seq := last.Seq + 1; seq < last.Seq+6; seq++ {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read resumed stream response: %v", err)
+		}
+		if res.JobId != jobId || res.Seq != seq || res.Error != nil {
+			t.Fatalf("expected response with seq %d; got %+v", seq, res)
+		}
+
+		var tick uint64
+		err = json.Unmarshal(res.Result, &tick)
+		if err != nil || tick+1 != seq {
+			t.Fatalf("expected tick %d; got %s", seq-1, res.Result)
+		}
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel})
+	if err != nil {
+		t.Fatalf("failed to cancel stream: %v", err)
+	}
+
+	for {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("no cancel acknowledgement received: %v", err)
+		}
+		if res.Error == nil {
+			continue
+		}
+		if res.JobId != jobId || res.Error.Code != wsrpc.Cancelled().Code {
+			t.Fatalf("expected cancel acknowledgement; got %+v", res.Error)
+		}
+
+		break
+	}
+}
+
+func TestResume_gap(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read stream response: %v", err)
+	}
+	conn.Close()
+
+	header := wsrpc.Headers{"resumeToken": res.Header.Get("resumeToken").StringOr("")}
+
+	// More responses than the replay buffer holds are produced while disconnected
+	time.Sleep(300 * time.Millisecond)
+
+	conn, _, err = websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`1`), Header: header})
+	if err != nil {
+		t.Fatalf("failed to resume stream: %v", err)
+	}
+
+	var gap wsrpc.Response
+	err = conn.ReadJSON(&gap)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if gap.JobId != jobId || gap.Error == nil || gap.Error.Code != wsrpc.ReplayGapError(1).Code {
+		t.Fatalf("expected replay gap error; got %+v", gap)
+	}
+
+	// Streams which are not resumed in time are ended
+	time.Sleep(time.Second)
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`1`), Header: header})
+	if err != nil {
+		t.Fatalf("failed to resume stream: %v", err)
+	}
+
+	var notFound wsrpc.Response
+	err = conn.ReadJSON(&notFound)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found after grace period; got %+v", notFound)
+	}
+}
+
+func TestResume_finished(t *testing.T) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	jobIds := []uuid.UUID{uuid.New(), uuid.New()}
+	header := wsrpc.Headers{"ticks": 5}
+	err = conn.WriteJSON([]wsrpc.Request{
+		{JobId: jobIds[0], Method: "ticker", Type: wsrpc.TypeStream, Header: header},
+		{JobId: jobIds[1], Method: "ticker", Type: wsrpc.TypeStream, Header: header},
+	})
+	if err != nil {
+		t.Fatalf("failed to start streams: %v", err)
+	}
+
+	var first wsrpc.Response
+	err = conn.ReadJSON(&first)
+	if err != nil {
+		t.Fatalf("failed to read stream response: %v", err)
+	}
+	conn.Close()
+
+	// Both streams end while the client is disconnected
+	time.Sleep(200 * time.Millisecond)
+
+	conn, _, err = websocket.DefaultDialer.Dial("ws://"+serviceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	// Resuming the first stream doesn't forget the second one before its last responses are replayed
+	token := wsrpc.Headers{"resumeToken": first.Header.Get("resumeToken").StringOr("")}
+	for _, jobId := range jobIds {
+		err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`0`), Header: token})
+		if err != nil {
+			t.Fatalf("failed to resume stream: %v", err)
+		}
+
+		for seq := uint64(1); ; seq++ {
+			var res wsrpc.Response
+			err = conn.ReadJSON(&res)
+			if err != nil {
+				t.Fatalf("failed to read resumed stream response: %v", err)
+			}
+			if res.JobId != jobId || res.Seq != seq {
+				t.Fatalf("expected response of %s with seq %d; got %+v", jobId, seq, res)
+			}
+			if res.Error != nil {
+				if res.Error.Code != wsrpc.EOF().Code {
+					t.Fatalf("expected EOF; got %+v", res.Error)
+				}
+				break
+			}
+		}
+	}
+}
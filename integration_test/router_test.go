@@ -12,6 +12,8 @@ import (
 // Example
 func setupRouter() *wsrpc.Router {
 	router := wsrpc.NewRouter()
+	router.SetStreamResumption(16, 500*time.Millisecond)
+	router.SetREST("/rpc/")
 
 	router.SetHandler("add", func(ctx wsrpc.Context) (err error) {
 		a := ctx.Request().Header.Get("A").IntOr(0)
@@ -23,7 +25,8 @@ func setupRouter() *wsrpc.Router {
 		default:
 		}
 
-		ctx.Response().Result, err = json.Marshal(a + b)
+		ctx.Response().Header.Set("terms", []int64{a, b})
+		ctx.Response().Result, err = ctx.Codec().Marshal(a + b)
 		if err != nil {
 			return err
 		}
@@ -35,7 +38,7 @@ func setupRouter() *wsrpc.Router {
 		var params struct {
 			Val int `json:"val"`
 		}
-		err = json.Unmarshal(ctx.Request().Params, &params)
+		err = ctx.Codec().Unmarshal(ctx.Request().Params, &params)
 		if err != nil {
 			return err
 		}
@@ -46,7 +49,7 @@ func setupRouter() *wsrpc.Router {
 		default:
 		}
 
-		ctx.Response().Result, err = json.Marshal(math.Pow(float64(params.Val), 2))
+		ctx.Response().Result, err = ctx.Codec().Marshal(math.Pow(float64(params.Val), 2))
 		if err != nil {
 			return err
 		}
@@ -127,5 +130,88 @@ func setupRouter() *wsrpc.Router {
 		return nil
 	})
 
+	router.SetStream("ticker", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) (err error) {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		// Tickers run until cancelled unless limited by the ticks header
+		ticks := ctx.Request().Header.Get("ticks").IntOr(-1)
+		for tick := 0; tick != int(ticks); tick++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			rsp := ctx.NewResponse()
+			rsp.Result, err = json.Marshal(tick)
+			if err != nil {
+				return err
+			}
+
+			err = ch.Write(rsp)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	router.SetHandler("notify", func(ctx wsrpc.Context) (err error) {
+		event := &wsrpc.Event{Event: "notified", Data: ctx.Request().Params}
+
+		err = ctx.Conn().Push(event)
+		if err != nil {
+			return err
+		}
+
+		// Events can be pushed to many connections at once, they are left as they are
+		if event.Type != "" || event.Header != nil {
+			return errors.New("pushed event was modified")
+		}
+
+		ctx.Response().Result, err = json.Marshal(true)
+
+		return err
+	})
+
+	router.SetHandler("ask", func(ctx wsrpc.Context) (err error) {
+		res, err := ctx.Conn().Call(ctx, "viewport", ctx.Request().Params)
+		if err != nil {
+			return err
+		}
+
+		ctx.Response().Result = res.Result
+
+		return nil
+	})
+
+	router.SetHandler("protocol", func(ctx wsrpc.Context) (err error) {
+		ctx.Response().Result, err = json.Marshal(ctx.Protocol().Name)
+
+		return err
+	})
+
+	router.SetBidiStream("echo", func(ctx wsrpc.Context, in *wsrpc.RequestChannel, out *wsrpc.ResponseChannel) (err error) {
+		for {
+			msg, err := in.Read()
+			if err == wsrpc.ErrChanClosed {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			rsp := ctx.NewResponse()
+			rsp.Result = msg.Params
+
+			err = out.Write(rsp)
+			if err != nil {
+				return err
+			}
+		}
+	})
+
 	return router
 }
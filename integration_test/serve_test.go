@@ -0,0 +1,111 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newServeRouter returns a router which records the errors passed to its error pipeline.
+func newServeRouter(errs *[]error, mutex *sync.Mutex) *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetErrorPostProc(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		*errs = append(*errs, err)
+	})
+
+	router.SetHandler("fail", func(ctx wsrpc.Context) error {
+		return errors.New("failed")
+	})
+
+	return router
+}
+
+// postFailing calls a failing method through url, it fails the test unless the call is answered in time.
+func postFailing(t *testing.T, url string, method string) {
+	t.Helper()
+
+	data, _ := json.Marshal(wsrpc.Request{Id: 1, Method: method, Type: wsrpc.TypeCall})
+	client := http.Client{Timeout: time.Second}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res wsrpc.Response
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Error == nil {
+		t.Fatalf("expected error response; got %+v", res)
+	}
+}
+
+func TestServe_mounted(t *testing.T) {
+	var errs []error
+	var mutex sync.Mutex
+	router := newServeRouter(&errs, &mutex)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", router)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Every unknown method is passed to the error pipeline, which used to block without Start
+	for i := 0; i < 3; i++ {
+		postFailing(t, server.URL+"/ws", "missing")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(errs) != 3 {
+		t.Fatalf("expected errors to be passed to the error pipeline; got %v", errs)
+	}
+}
+
+func TestServe_listener(t *testing.T) {
+	var errs []error
+	var mutex sync.Mutex
+	router := newServeRouter(&errs, &mutex)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- router.Serve(listener)
+	}()
+
+	postFailing(t, "http://"+listener.Addr().String(), "fail")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = router.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	err = <-served
+	if !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected the server to be closed; got %v", err)
+	}
+}
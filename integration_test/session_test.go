@@ -0,0 +1,284 @@
+package integration_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newSessionRouter returns a router keeping sessions for a second after their last connection is gone.
+func newSessionRouter() *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetSessions(time.Second)
+
+	router.SetHandler("remember", func(ctx wsrpc.Context) (err error) {
+		var name string
+		err = json.Unmarshal(ctx.Request().Params, &name)
+		if err != nil {
+			return err
+		}
+
+		ctx.Session().Set("name", name)
+		ctx.Response().Result, err = json.Marshal(ctx.Session().Id())
+
+		return err
+	})
+	router.SetHandler("recall", func(ctx wsrpc.Context) (err error) {
+		ctx.Response().Result, err = json.Marshal(ctx.Session().Get("name").StringOr(""))
+
+		return err
+	})
+	router.SetHandler("sleep", func(ctx wsrpc.Context) (err error) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		ctx.Response().Result, err = json.Marshal("awake")
+
+		return err
+	})
+	router.SetStream("ticker", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) (err error) {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for tick := 0; ; tick++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			rsp := ctx.NewResponse()
+			rsp.Result, err = json.Marshal(tick)
+			if err != nil {
+				return err
+			}
+
+			err = ch.Write(rsp)
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	return router
+}
+
+func TestSession(t *testing.T) {
+	server := httptest.NewServer(newSessionRouter())
+	defer server.Close()
+
+	conn, rsp := dial(t, server, nil)
+
+	sessionId := rsp.Header.Get(wsrpc.SessionHeader)
+	if sessionId == "" {
+		t.Fatalf("expected session id to be issued")
+	}
+	if cookie := rsp.Header.Get("Set-Cookie"); !strings.Contains(cookie, "SameSite=Lax") || strings.Contains(cookie, "Secure") {
+		t.Fatalf("expected a same site cookie which is not restricted to TLS; got %s", cookie)
+	}
+
+	remembered := send(t, conn, wsrpc.Request{Method: "remember", Type: wsrpc.TypeCall, Params: json.RawMessage(`"gopher"`)})
+	if string(remembered.Result) != `"`+sessionId+`"` {
+		t.Fatalf("expected handler to see session %s; got %s", sessionId, remembered.Result)
+	}
+
+	// The session state is shared with long poll requests presenting the session id
+	body, _ := json.Marshal(wsrpc.Request{Id: 1, Method: "recall", Type: wsrpc.TypeCall})
+	req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+	req.Header.Set(wsrpc.SessionHeader, sessionId)
+
+	hrsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to long poll: %v", err)
+	}
+	data, _ := ioutil.ReadAll(hrsp.Body)
+	hrsp.Body.Close()
+
+	var recalled wsrpc.Response
+	err = json.Unmarshal(data, &recalled)
+	if err != nil || string(recalled.Result) != `"gopher"` {
+		t.Fatalf("expected session state to be recalled; got %s", data)
+	}
+
+	jobId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+
+	var last wsrpc.Response
+	for i := 0; i < 2; i++ {
+		err = conn.ReadJSON(&last)
+		if err != nil {
+			t.Fatalf("failed to read stream response: %v", err)
+		}
+	}
+	conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Clients outside of the session can not resume its streams
+	other, _ := dial(t, server, nil)
+
+	notFound := send(t, other, wsrpc.Request{JobId: jobId, Type: wsrpc.TypeResume, Params: json.RawMessage(`2`)})
+	if notFound.Error == nil || notFound.Error.Code != wsrpc.JobNotFoundError(jobId).Code {
+		t.Fatalf("expected job not found outside of the session; got %+v", notFound)
+	}
+
+	// Reconnecting with the session id re-attaches the running stream
+	conn, _ = dial(t, server, http.Header{wsrpc.SessionHeader: {sessionId}})
+
+	var prev wsrpc.Response
+	for i := 0; i < 5; i++ {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("failed to read re-attached stream response: %v", err)
+		}
+		if res.JobId != jobId || res.Error != nil || res.Seq <= last.Seq || (i > 0 && res.Seq != prev.Seq+1) {
+			t.Fatalf("expected stream to continue after seq %d; got %+v", last.Seq, res)
+		}
+		prev = res
+	}
+
+	err = conn.WriteJSON(wsrpc.Request{JobId: jobId, Type: wsrpc.TypeCancel})
+	if err != nil {
+		t.Fatalf("failed to cancel stream: %v", err)
+	}
+
+	for {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			t.Fatalf("no cancel acknowledgement received: %v", err)
+		}
+		if res.Error == nil {
+			continue
+		}
+		if res.JobId != jobId || res.Error.Code != wsrpc.Cancelled().Code {
+			t.Fatalf("expected cancel acknowledgement; got %+v", res.Error)
+		}
+
+		break
+	}
+}
+
+func TestSession_calls(t *testing.T) {
+	server := httptest.NewServer(newSessionRouter())
+	defer server.Close()
+
+	conn, rsp := dial(t, server, nil)
+	sessionId := rsp.Header.Get(wsrpc.SessionHeader)
+
+	jobId := uuid.New()
+	err := conn.WriteJSON(wsrpc.Request{JobId: jobId, Method: "sleep", Type: wsrpc.TypeCall})
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+
+	// The call keeps running and its result is passed on to the next connection of the session
+	conn, _ = dial(t, server, http.Header{wsrpc.SessionHeader: {sessionId}})
+
+	var res wsrpc.Response
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.JobId != jobId || res.Error != nil || string(res.Result) != `"awake"` {
+		t.Fatalf("expected the result of the call made before reconnecting; got %+v", res)
+	}
+}
+
+func TestSession_follow(t *testing.T) {
+	server := httptest.NewServer(newSessionRouter())
+	defer server.Close()
+
+	conn, rsp := dial(t, server, nil)
+	sessionId := rsp.Header.Get(wsrpc.SessionHeader)
+
+	callId, streamId := uuid.New(), uuid.New()
+	err := conn.WriteJSON(wsrpc.Request{JobId: callId, Method: "sleep", Type: wsrpc.TypeCall})
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	err = conn.WriteJSON(wsrpc.Request{JobId: streamId, Method: "ticker", Type: wsrpc.TypeStream})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// The session carries on over server-sent events
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(wsrpc.SessionHeader, sessionId)
+
+	hrsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request events: %v", err)
+	}
+	defer hrsp.Body.Close()
+
+	events := followEvents(t, hrsp.Body)
+
+	var called, streamed bool
+	timeout := time.After(2 * time.Second)
+	for !called || !streamed {
+		var res wsrpc.Response
+		select {
+		case res = <-events:
+		case <-timeout:
+			t.Fatalf("expected the call and the stream to be followed; got call %v, stream %v", called, streamed)
+		}
+
+		switch res.JobId {
+		case callId:
+			called = string(res.Result) == `"awake"`
+		case streamId:
+			streamed = res.Error == nil
+		}
+	}
+}
+
+// followEvents passes the data of the server-sent events read from r on as responses.
+func followEvents(t *testing.T, r io.Reader) <-chan wsrpc.Response {
+	events := make(chan wsrpc.Response, 64)
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var res wsrpc.Response
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res)
+			if err != nil {
+				t.Errorf("failed to decode event: %v", err)
+				return
+			}
+
+			events <- res
+		}
+	}()
+
+	return events
+}
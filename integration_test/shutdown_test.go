@@ -0,0 +1,146 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/modfin/wsrpc"
+)
+
+const shutdownServiceUrl = "localhost:10111"
+
+func TestShutdown(t *testing.T) {
+	router := wsrpc.NewRouter()
+	router.SetErrorPostProc(func(err error) {})
+
+	router.SetHandler("slow", func(ctx wsrpc.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		ctx.Response().Result = json.RawMessage(`"done"`)
+		return nil
+	})
+	router.SetStream("tick", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	started := make(chan error, 1)
+	go func() {
+		started <- router.Start(shutdownServiceUrl)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+shutdownServiceUrl, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	tickId, slowId := uuid.New(), uuid.New()
+	err = conn.WriteJSON([]wsrpc.Request{
+		{JobId: tickId, Method: "tick", Type: wsrpc.TypeStream},
+		{JobId: slowId, Method: "slow", Type: wsrpc.TypeCall},
+	})
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	// Streams served over HTTP hold up the server until they end
+	req, _ := http.NewRequest(http.MethodGet, "http://"+shutdownServiceUrl+"/?method=tick", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	events, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request events: %v", err)
+	}
+	defer events.Body.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		shutdown <- router.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// No new jobs are started during the shutdown
+	lateId := uuid.New()
+	err = conn.WriteJSON(wsrpc.Request{JobId: lateId, Method: "slow", Type: wsrpc.TypeCall})
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	// No new connections are accepted during the shutdown
+	data, _ := json.Marshal(wsrpc.Request{Id: 1, Method: "slow", Type: wsrpc.TypeCall})
+	resp, err := http.Post("http://"+shutdownServiceUrl, "application/json", bytes.NewReader(data))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected new connections to be refused; got status %d", resp.StatusCode)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	responses := make(map[uuid.UUID]wsrpc.Response)
+	for {
+		var res wsrpc.Response
+		err = conn.ReadJSON(&res)
+		if err != nil {
+			break
+		}
+
+		responses[res.JobId] = res
+	}
+
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected the server to go away; got %v", err)
+	}
+	if res := responses[slowId]; res.Error != nil || string(res.Result) != `"done"` {
+		t.Fatalf("expected the running call to finish; got %+v", res)
+	}
+	if res := responses[lateId]; res.Error == nil || res.Error.Code != wsrpc.ShutdownError().Code {
+		t.Fatalf("expected the late call to be rejected; got %+v", res)
+	}
+	if res := responses[tickId]; res.Error == nil || res.Error.Code != wsrpc.ShutdownError().Code {
+		t.Fatalf("expected the running stream to be ended; got %+v", res)
+	}
+
+	body, err := ioutil.ReadAll(events.Body)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	if !bytes.Contains(body, []byte(`"code":503`)) {
+		t.Fatalf("expected the event stream to be ended with the shutdown error; got %s", body)
+	}
+
+	select {
+	case err = <-shutdown:
+		if err != nil {
+			t.Fatalf("failed to shut down: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected shutdown to return")
+	}
+
+	// Only the running call is waited for, not the streams
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("expected shutdown to return once the call finished; got %v", elapsed)
+	}
+
+	err = <-started
+	if !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected the server to be closed; got %v", err)
+	}
+}
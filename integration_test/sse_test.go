@@ -0,0 +1,110 @@
+package integration_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/modfin/wsrpc"
+)
+
+// readEvents reads the data of all server-sent events of rsp.
+func readEvents(t *testing.T, rsp *http.Response) []wsrpc.Response {
+	defer rsp.Body.Close()
+
+	if ct := rsp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream; got %s", ct)
+	}
+
+	var events []wsrpc.Response
+	var id string
+	scanner := bufio.NewScanner(rsp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var res wsrpc.Response
+		err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res)
+		if err != nil {
+			t.Fatalf("failed to unmarshal event: %v", err)
+		}
+		if expected := fmt.Sprintf("%s:%d", res.JobId, res.Seq); id != expected {
+			t.Fatalf("expected event id %s; got %s", expected, id)
+		}
+		events = append(events, res)
+	}
+
+	return events
+}
+
+func TestSSE(t *testing.T) {
+	query := url.Values{}
+	query.Set("method", "countdown")
+	query.Set("header", `{"state": 3}`)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+serviceUrl+"/?"+query.Encode(), nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request event stream: %v", err)
+	}
+
+	events := readEvents(t, rsp)
+	if len(events) != 4 {
+		t.Fatalf("expected 3 responses and EOF; got %+v", events)
+	}
+	for i, res := range events[:3] {
+		if string(res.Result) != []string{"3", "2", "1"}[i] || res.Seq != uint64(i+1) {
+			t.Fatalf("unexpected response %d: %+v", i, res)
+		}
+	}
+	if events[3].Error == nil || events[3].Error.Code != wsrpc.EOF().Code {
+		t.Fatalf("expected EOF; got %+v", events[3])
+	}
+}
+
+func TestSSE_post(t *testing.T) {
+	body, _ := json.Marshal(wsrpc.Request{Id: 1, Method: "countdown", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"state": 2}})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+serviceUrl, bytes.NewReader(body))
+	req.Header.Set("Accept", "text/event-stream")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request event stream: %v", err)
+	}
+
+	events := readEvents(t, rsp)
+	if len(events) != 3 || events[2].Error == nil || events[2].Error.Code != wsrpc.EOF().Code {
+		t.Fatalf("expected 2 responses and EOF; got %+v", events)
+	}
+}
+
+func TestSSE_call(t *testing.T) {
+	body, _ := json.Marshal(wsrpc.Request{Id: 1, Method: "add", Type: wsrpc.TypeCall})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+serviceUrl, bytes.NewReader(body))
+	req.Header.Set("Accept", "text/event-stream")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request event stream: %v", err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected calls to be rejected; got %d", rsp.StatusCode)
+	}
+}
@@ -0,0 +1,175 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/modfin/wsrpc"
+)
+
+// newTimeoutRouter returns a router bounding its jobs by timeouts.
+func newTimeoutRouter() *wsrpc.Router {
+	router := wsrpc.NewRouter()
+	router.SetTimeouts(0, time.Second)
+	router.SetLimits(wsrpc.Limits{MaxJobs: 1})
+	router.SetREST("/rpc/")
+
+	// hang ignores its context, as a hung handler would
+	router.SetHandler("hang", func(ctx wsrpc.Context) error {
+		time.Sleep(5 * time.Second)
+		return nil
+	})
+	router.SetHandler("wait", func(ctx wsrpc.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}).Timeout(50*time.Millisecond, 300*time.Millisecond)
+	router.SetStream("tick", func(ctx wsrpc.Context, ch *wsrpc.ResponseChannel) (err error) {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for tick := 0; ; tick++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			rsp := ctx.NewResponse()
+			rsp.Result, err = json.Marshal(tick)
+			if err != nil {
+				return err
+			}
+
+			err = ch.Write(rsp)
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	return router
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(newTimeoutRouter())
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		req      wsrpc.Request
+		code     int
+		within   time.Duration
+		atLeast  time.Duration
+		deadline time.Duration
+		response bool
+	}{
+		{name: "requested", req: wsrpc.Request{Method: "hang", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 100}}, code: wsrpc.TimeoutError().Code, atLeast: 100 * time.Millisecond, within: 500 * time.Millisecond},
+		{name: "router maximum", req: wsrpc.Request{Method: "hang", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 60000}}, code: wsrpc.TimeoutError().Code, atLeast: time.Second, within: 2 * time.Second},
+		{name: "method default", req: wsrpc.Request{Method: "wait", Type: wsrpc.TypeCall}, code: wsrpc.TimeoutError().Code, atLeast: 50 * time.Millisecond, within: 250 * time.Millisecond},
+		{name: "method maximum", req: wsrpc.Request{Method: "wait", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 60000}}, code: wsrpc.TimeoutError().Code, atLeast: 300 * time.Millisecond, within: 800 * time.Millisecond},
+		{name: "clamped", req: wsrpc.Request{Method: "hang", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 1e15}}, code: wsrpc.TimeoutError().Code, atLeast: time.Second, within: 2 * time.Second},
+		{name: "deadline", req: wsrpc.Request{Method: "hang", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 800}}, deadline: 100 * time.Millisecond, code: wsrpc.TimeoutError().Code, atLeast: 100 * time.Millisecond, within: 500 * time.Millisecond},
+		{name: "past deadline", req: wsrpc.Request{Method: "wait", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"deadline": "2020-01-01T00:00:00Z"}}, code: wsrpc.TimeoutError().Code, within: 200 * time.Millisecond},
+		{name: "invalid deadline", req: wsrpc.Request{Method: "wait", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"deadline": "tomorrow"}}, code: wsrpc.InvalidRequestError("").Code, within: 500 * time.Millisecond},
+		{name: "invalid", req: wsrpc.Request{Method: "wait", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": "soon"}}, code: wsrpc.InvalidRequestError("").Code, within: 500 * time.Millisecond},
+		{name: "stream", req: wsrpc.Request{Method: "tick", Type: wsrpc.TypeStream, Header: wsrpc.Headers{"timeout": 100}}, code: wsrpc.TimeoutError().Code, atLeast: 100 * time.Millisecond, within: 500 * time.Millisecond, response: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, _ := dial(t, server, nil)
+
+			tc.req.JobId = uuid.New()
+			start := time.Now()
+			if tc.deadline > 0 {
+				tc.req.Header["deadline"] = start.Add(tc.deadline).Format(time.RFC3339Nano)
+			}
+			err := conn.WriteJSON(tc.req)
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+
+			var results int
+			for {
+				var res wsrpc.Response
+				err = conn.ReadJSON(&res)
+				if err != nil {
+					t.Fatalf("failed to read response: %v", err)
+				}
+				if res.JobId != tc.req.JobId {
+					t.Fatalf("unexpected response: %+v", res)
+				}
+
+				if res.Error == nil {
+					results++
+					continue
+				}
+
+				elapsed := time.Since(start)
+				if res.Error.Code != tc.code {
+					t.Fatalf("expected error code %d; got %+v", tc.code, res.Error)
+				}
+				if elapsed < tc.atLeast || elapsed > tc.within {
+					t.Fatalf("expected response after %v to %v; got %v", tc.atLeast, tc.within, elapsed)
+				}
+				if tc.response && results == 0 {
+					t.Fatalf("expected stream responses before the timeout")
+				}
+
+				break
+			}
+		})
+	}
+}
+
+func TestTimeout_slot(t *testing.T) {
+	server := httptest.NewServer(newTimeoutRouter())
+	defer server.Close()
+
+	conn, _ := dial(t, server, nil)
+
+	// The hung handler keeps its job slot after its job has timed out
+	for _, code := range []int{wsrpc.TimeoutError().Code, wsrpc.LimitExceededError("").Code} {
+		res := send(t, conn, wsrpc.Request{Method: "hang", Type: wsrpc.TypeCall, Header: wsrpc.Headers{"timeout": 50}})
+		if res.Error == nil || res.Error.Code != code {
+			t.Fatalf("expected error code %d; got %+v", code, res)
+		}
+	}
+}
+
+func TestTimeout_REST(t *testing.T) {
+	server := httptest.NewServer(newTimeoutRouter())
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{name: "timeout", header: http.Header{"Timeout": {"100"}}},
+		{name: "deadline", header: http.Header{"Deadline": {time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/rpc/hang", nil)
+			req.Header = tc.header
+
+			start := time.Now()
+			rsp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to request: %v", err)
+			}
+			rsp.Body.Close()
+
+			// HTTP headers arrive in canonical form, they are still taken as the timeout and deadline of the job
+			if rsp.StatusCode != http.StatusGatewayTimeout || time.Since(start) > 500*time.Millisecond {
+				t.Fatalf("expected the call to time out; got %d after %v", rsp.StatusCode, time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package wsrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const jsonrpcVersion = "2.0"
+
+var (
+	// ProtocolJSONRPC is the protocol of all connections to a router in JSON-RPC 2.0 mode.
+	ProtocolJSONRPC = &Protocol{
+		Name:  "jsonrpc-2.0",
+		Codec: JSONCodec,
+	}
+
+	jsonNull = json.RawMessage("null")
+)
+
+// jsonrpcResponse is the JSON-RPC 2.0 representation of a Response.
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+func newJSONRPCResponse(id json.RawMessage, res *Response) *jsonrpcResponse {
+	if id == nil {
+		id = jsonNull
+	}
+
+	rsp := &jsonrpcResponse{
+		JSONRPC: jsonrpcVersion,
+		Error:   res.Error,
+		Id:      id,
+	}
+
+	if rsp.Error == nil {
+		rsp.Result = res.Result
+		if len(rsp.Result) == 0 {
+			rsp.Result = jsonNull
+		}
+	}
+
+	return rsp
+}
+
+// SetJSONRPC toggles the strict JSON-RPC 2.0 mode of the router.
+// In this mode every connection accepts and emits JSON-RPC 2.0 messages only, which are served by the registered call handlers.
+func (r *Router) SetJSONRPC(enabled bool) {
+	r.jsonrpc = enabled
+
+	r.wsUpgrade.Subprotocols = nil
+	if enabled {
+		r.wsUpgrade.Subprotocols = []string{ProtocolJSONRPC.Name}
+		return
+	}
+
+	for _, protocol := range r.protocols {
+		r.wsUpgrade.Subprotocols = append(r.wsUpgrade.Subprotocols, protocol.Name)
+	}
+}
+
+// createJSONRPCBatch decodes the JSON-RPC 2.0 requests in data into a batch of call jobs.
+// Errors concerning the message as a whole are returned as *Error, errors concerning single requests are attached to their jobs.
+func createJSONRPCBatch(parent context.Context, codec Codec, data []byte, httpRequest *http.Request) (*batch, error) {
+	batch := batch{
+		isSlice:   codec.IsBatch(data),
+		isJSONRPC: true,
+	}
+
+	var messages []json.RawMessage
+	if batch.isSlice {
+		err := codec.Unmarshal(data, &messages)
+		if err != nil {
+			return nil, ParseError()
+		}
+	}
+
+	if !batch.isSlice {
+		var message json.RawMessage
+		err := codec.Unmarshal(data, &message)
+		if err != nil {
+			return nil, ParseError()
+		}
+
+		messages = []json.RawMessage{message}
+	}
+
+	if len(messages) < 1 {
+		return nil, InvalidRequestError("empty batch")
+	}
+
+	requests := make([]Request, len(messages))
+	invalid := make([]*Error, len(messages))
+	for i, message := range messages {
+		requests[i], invalid[i] = parseJSONRPCRequest(message)
+	}
+
+	batch.start(parent, requests, httpRequest)
+	for i := range batch.jobs {
+		batch.jobs[i].invalid = invalid[i]
+	}
+
+	return &batch, nil
+}
+
+// parseJSONRPCRequest turns a single JSON-RPC 2.0 request into a call request.
+// If the request is not valid an error is returned along with a request carrying as much as could be recovered, i.e. its id.
+func parseJSONRPCRequest(message json.RawMessage) (Request, *Error) {
+	req := newRequest()
+	req.JobId = uuid.New()
+	req.Type = TypeCall
+
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(message, &members)
+	if err != nil || members == nil {
+		return req, InvalidRequestError("request must be an object")
+	}
+
+	id, hasId := members["id"]
+	req.notification = !hasId
+	if hasId {
+		req.rpcId = id
+
+		switch firstByte(id) {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		default:
+			req.rpcId = jsonNull
+			req.notification = false
+			return req, InvalidRequestError("id must be a string, number or null")
+		}
+	}
+
+	var version string
+	err = json.Unmarshal(members["jsonrpc"], &version)
+	if err != nil || version != jsonrpcVersion {
+		req.notification = false
+		return req, InvalidRequestError("jsonrpc must be exactly \"2.0\"")
+	}
+
+	err = json.Unmarshal(members["method"], &req.Method)
+	if err != nil || req.Method == "" {
+		req.notification = false
+		return req, InvalidRequestError("method must be a string")
+	}
+
+	params, hasParams := members["params"]
+	if hasParams {
+		switch firstByte(params) {
+		case '{', '[':
+			req.Params = params
+		default:
+			req.notification = false
+			return req, InvalidRequestError("params must be an object or an array")
+		}
+	}
+
+	return req, nil
+}
+
+func firstByte(data json.RawMessage) byte {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0
+	}
+
+	return data[0]
+}
+
+// jsonrpcResponses converts the responses of a JSON-RPC 2.0 batch into their JSON-RPC 2.0 representation.
+func (b *batch) jsonrpcResponses(responses []*Response) []*jsonrpcResponse {
+	rsps := make([]*jsonrpcResponse, 0, len(responses))
+	for _, res := range responses {
+		var id json.RawMessage
+		job := b.job(res.JobId)
+		if job != nil {
+			id = job.request.rpcId
+		}
+
+		rsps = append(rsps, newJSONRPCResponse(id, res))
+	}
+
+	return rsps
+}
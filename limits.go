@@ -0,0 +1,117 @@
+package wsrpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Limits caps the resources a single client can claim, zero means no limit.
+type Limits struct {
+	// MaxMessageSize caps the size in bytes of web socket messages and HTTP request bodies.
+	// Web socket connections sending larger messages are closed, HTTP requests are answered with 413 Request Entity Too Large.
+	MaxMessageSize int64
+	// MaxBatchSize caps the number of requests in a batch, every request of a larger batch is answered with an error.
+	MaxBatchSize int
+	// MaxJobs caps the number of jobs running concurrently per connection, requests beyond it are answered with an error.
+	MaxJobs int
+	// MaxStreams caps the number of streams running concurrently per connection, requests beyond it are answered with an error.
+	MaxStreams int
+}
+
+// SetLimits caps the resources a single client can claim.
+func (r *Router) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// limitBody caps the size of the body of the HTTP request of sock.
+func (r *Router) limitBody(sock *socket) {
+	if r.limits.MaxMessageSize > 0 {
+		sock.req.Body = http.MaxBytesReader(sock.w, sock.req.Body, r.limits.MaxMessageSize)
+	}
+}
+
+// limitBatch answers every request of batch with an error if the batch holds more requests than allowed, instead of running them.
+func (r *Router) limitBatch(batch *batch) {
+	if r.limits.MaxBatchSize == 0 || len(batch.jobs) <= r.limits.MaxBatchSize {
+		return
+	}
+
+	for i := range batch.jobs {
+		batch.jobs[i].invalid = LimitExceededError(fmt.Sprintf("batches are limited to %d requests", r.limits.MaxBatchSize))
+	}
+}
+
+// newJobLimiter returns the limiter of the jobs of a connection, or nil if the router doesn't limit them.
+func (r *Router) newJobLimiter() *jobLimiter {
+	if r.limits.MaxJobs == 0 && r.limits.MaxStreams == 0 {
+		return nil
+	}
+
+	return &jobLimiter{
+		maxJobs:    r.limits.MaxJobs,
+		maxStreams: r.limits.MaxStreams,
+	}
+}
+
+// jobLimiter caps the number of jobs and streams running concurrently on a connection.
+type jobLimiter struct {
+	mutex      sync.Mutex
+	jobs       int
+	streams    int
+	maxJobs    int
+	maxStreams int
+}
+
+// acquire claims a slot for the job of req, it returns an error if the connection has no slot left.
+// A nil limiter has slots for every job.
+func (l *jobLimiter) acquire(req *Request) *Error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxJobs > 0 && l.jobs >= l.maxJobs {
+		return LimitExceededError(fmt.Sprintf("connections are limited to %d concurrent jobs", l.maxJobs))
+	}
+
+	stream := req.Type == TypeStream
+	if stream && l.maxStreams > 0 && l.streams >= l.maxStreams {
+		return LimitExceededError(fmt.Sprintf("connections are limited to %d concurrent streams", l.maxStreams))
+	}
+
+	l.jobs++
+	if stream {
+		l.streams++
+	}
+
+	return nil
+}
+
+// release frees the slot of the job of req.
+func (l *jobLimiter) release(req *Request) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.jobs--
+	if req.Type == TypeStream {
+		l.streams--
+	}
+}
+
+// httpError answers the HTTP request with err and status, or with 413 Request Entity Too Large if err is the result of a body exceeding the size limit.
+func httpError(w http.ResponseWriter, err error, status int) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,122 @@
+package wsrpc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SetLongPollStreams keeps streams requested through long poll running between polls, rather than answering with their first response.
+// A poll waits up to wait for responses and streams which are not polled within the grace period are ended.
+// A wait of zero disables long poll streams.
+func (r *Router) SetLongPollStreams(wait, grace time.Duration) {
+	r.pollWait = wait
+	r.pollGrace = grace
+}
+
+// longPollStreams reports whether or not the batch is served as a long poll stream, i.e. it starts or polls one.
+func (r *Router) longPollStreams(sock *socket, batch *batch) bool {
+	if r.pollWait <= 0 || !sock.protocol.Supports(FeatureResume) {
+		return false
+	}
+
+	return batch.isStream || batch.isControl
+}
+
+// startLongPollStream starts the streams of batch detached from the long poll request and answers with their first responses.
+func (r *Router) startLongPollStream(sock *socket, batch *batch) error {
+	res := r.resumable(sock, batch)
+	if res == nil {
+		return ErrClientDisconnected
+	}
+	res.grace = r.pollGrace
+	res.detach(sock)
+
+	go r.routeRequest(batch, sock.channel)
+
+	seqs := make(map[uuid.UUID]uint64, len(batch.jobs))
+	for i := range batch.jobs {
+		seqs[batch.jobs[i].request.JobId] = 0
+	}
+
+	return r.writePoll(sock, &batch.jobs[0], res, seqs)
+}
+
+// pollLongPollStream applies a batch of control requests addressing long poll streams.
+// Its RESUME requests are answered with all responses of the named streams after the seqs they carry,
+// the other requests are applied as they would be on a web socket, and are answered with 204 No Content if they are not accompanied by a poll.
+// All streams of a poll have to be started by the same request.
+func (r *Router) pollLongPollStream(sock *socket, batch *batch) error {
+	var res *resumption
+	seqs := make(map[uuid.UUID]uint64, len(batch.jobs))
+	for i := range batch.jobs {
+		job := &batch.jobs[i]
+		if job.request.Type != TypeResume {
+			rspErr := r.controlLongPollStream(sock, job)
+			if rspErr != nil {
+				return r.writePollError(sock, job, rspErr)
+			}
+			continue
+		}
+
+		stream, seq, rspErr := r.resumed(sock, job.request)
+		if rspErr == nil && res != nil && stream != res {
+			rspErr = InvalidRequestError("polled streams must be started by the same request")
+		}
+		if rspErr != nil {
+			return r.writePollError(sock, job, rspErr)
+		}
+
+		res = stream
+		seqs[job.request.JobId] = seq
+	}
+
+	if res == nil {
+		sock.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return r.writePoll(sock, &batch.jobs[0], res, seqs)
+}
+
+// controlLongPollStream applies the control request of job, e.g. CANCEL, to the long poll stream it addresses.
+// Responses acknowledging it, such as that of a cancellation, are buffered for the next poll.
+func (r *Router) controlLongPollStream(sock *socket, job *job) *Error {
+	if job.invalid != nil {
+		return job.invalid
+	}
+
+	res, rspErr := r.ownedStream(sock, job.request)
+	if rspErr != nil {
+		return rspErr
+	}
+
+	// The stream is addressed through the request, as it would be through the connection it was started on
+	sock.addBatch(res.batch)
+
+	return r.controlJob(sock, job.request)
+}
+
+// writePoll answers the long poll request with the responses of the streams after seqs,
+// or with 204 No Content if there are none within the wait of a poll.
+func (r *Router) writePoll(sock *socket, job *job, res *resumption, seqs map[uuid.UUID]uint64) error {
+	responses, rspErr := res.poll(seqs, r.pollWait, sock.req.Context().Done())
+	if rspErr != nil {
+		return r.writePollError(sock, job, rspErr)
+	}
+
+	if len(responses) == 0 {
+		sock.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return r.writeLongPoll(sock, responses)
+}
+
+func (r *Router) writePollError(sock *socket, job *job, rspErr *Error) error {
+	resp := job.NewResponse()
+	resp.Error = rspErr
+
+	return r.writeLongPoll(sock, resp)
+}
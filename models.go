@@ -14,8 +14,27 @@ const (
 	TypeStream RequestType = `STREAM`
 	// TypeCall refers to jobs of on demand requests character.
 	TypeCall RequestType = `CALL`
+	// TypeNotify refers to on demand requests which are never answered, i.e. notifications.
+	TypeNotify RequestType = `NOTIFY`
+	// TypeCancel refers to requests cancelling a running job, identified by its JobId.
+	TypeCancel RequestType = `CANCEL`
+	// TypeMessage refers to follow-up messages sent to a running bidirectional stream, identified by its JobId.
+	TypeMessage RequestType = `MESSAGE`
+	// TypeClose refers to requests half-closing the requesters side of a running bidirectional stream, identified by its JobId.
+	TypeClose RequestType = `CLOSE`
+	// TypeCredit refers to requests granting a running stream under flow control more credits, identified by its JobId.
+	TypeCredit RequestType = `CREDIT`
+	// TypeResume refers to requests resuming a running stream on a new connection, identified by its JobId.
+	TypeResume RequestType = `RESUME`
+	// TypeEvent refers to messages pushed by the server which are not tied to any job.
+	TypeEvent RequestType = `EVENT`
 )
 
+// isControl reports whether or not requests of the type address a running job rather than starting a new one.
+func (t RequestType) isControl() bool {
+	return t == TypeCancel || t == TypeMessage || t == TypeClose || t == TypeCredit || t == TypeResume
+}
+
 // Response is serialized and passed back to the requester.
 type Response struct {
 	Id     int             `json:"id"` // Legacy
@@ -23,6 +42,7 @@ type Response struct {
 	Result json.RawMessage `json:"result"`
 	Header Headers         `json:"header"`
 	Error  *Error          `json:"error"`
+	Seq    uint64          `json:"seq,omitempty"` // Responses of batches containing streams only
 }
 
 func newResponse(id int, jobId uuid.UUID, err *Error) *Response {
@@ -42,6 +62,9 @@ type Request struct {
 	Type   RequestType     `json:"type"`
 	Params json.RawMessage `json:"params"`
 	Header Headers         `json:"header"`
+
+	rpcId        json.RawMessage
+	notification bool
 }
 type RequestTarget Request
 
@@ -71,3 +94,20 @@ func newRequest() Request {
 		Header: NewHeader(),
 	}
 }
+
+// Event is pushed to the client by the server outside of any job.
+type Event struct {
+	Type   RequestType     `json:"type"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+	Header Headers         `json:"header"`
+}
+
+// NewEvent returns a new event with the given name which can be pushed to a client through its Conn.
+func NewEvent(name string) *Event {
+	return &Event{
+		Type:   TypeEvent,
+		Event:  name,
+		Header: NewHeader(),
+	}
+}
@@ -0,0 +1,87 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+var (
+	errNDJSONUnsupported = errors.New("newline delimited json is not supported by the response writer")
+)
+
+// acceptsNDJSON reports whether or not a POST request asks for the responses as newline delimited json.
+func acceptsNDJSON(req *http.Request) bool {
+	if req.Method != http.MethodPost {
+		return false
+	}
+
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err == nil && (mediaType == ndjsonContentType || mediaType == "application/ndjson") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// startNDJSON serves the requested batch through a chunked response of newline delimited json.
+// Every response of a stream, or the result of every job of a call batch, is written on a line of its own as soon as it is produced.
+// Within a session a request without a body follows the session instead, see followSession.
+// Requests and lines are always json, as are the params and results of their jobs.
+func (r *Router) startNDJSON(sock *socket) error {
+	flusher, ok := sock.w.(http.Flusher)
+	if !ok {
+		return errNDJSONUnsupported
+	}
+	sock.codec = JSONCodec
+
+	data, err := ioutil.ReadAll(sock.req.Body)
+	if err != nil {
+		return err
+	}
+
+	// The encoder terminates every message with a newline
+	enc := json.NewEncoder(sock.w)
+
+	if len(data) == 0 && sock.session != nil {
+		writeNDJSONHeader(sock.w, flusher)
+		r.followSession(sock, flusher, enc.Encode)
+
+		return nil
+	}
+
+	batch, err := r.createBatch(sock, data)
+	if err != nil {
+		rsp := r.rejection(err)
+		if rsp == nil {
+			return err
+		}
+
+		r.report(r.errPreProc(err))
+		sock.w.Header().Set("Content-Type", ndjsonContentType)
+
+		return json.NewEncoder(sock.w).Encode(rsp)
+	}
+	r.applyBatchMode(batch, true)
+	batch.isIncremental = true
+
+	sock.addBatch(batch)
+
+	writeNDJSONHeader(sock.w, flusher)
+	r.flushOutput(sock, batch, flusher, enc.Encode)
+
+	return nil
+}
+
+func writeNDJSONHeader(w http.ResponseWriter, flusher http.Flusher) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+}
@@ -0,0 +1,113 @@
+package wsrpc
+
+// Feature denotes optional parts of wsrpc which a protocol may or may not support.
+type Feature uint
+
+const (
+	// FeatureCancel allows clients to cancel running jobs through CANCEL requests.
+	FeatureCancel Feature = 1 << iota
+	// FeaturePush allows the server to push events to clients.
+	FeaturePush
+	// FeatureClientCalls allows the server to call methods registered on clients.
+	FeatureClientCalls
+	// FeatureBidiStreams allows clients to send follow-up messages to bidirectional streams.
+	FeatureBidiStreams
+	// FeatureFlowControl allows clients to limit the responses of streams to the credits they grant through CREDIT requests.
+	FeatureFlowControl
+	// FeatureResume allows clients to resume streams on a new connection through RESUME requests.
+	FeatureResume
+)
+
+// Protocol describes a dialect of wsrpc which web socket clients negotiate as subprotocol when connecting.
+// The negotiated protocol drives the codec, error format and feature set of the connection.
+type Protocol struct {
+	// Name is the subprotocol name, e.g. wsrpc.v2+json.
+	Name string
+	// Version is the version of the wsrpc protocol.
+	Version int
+	// Codec is used to encode and decode the messages of the connection.
+	Codec Codec
+	// Features are the optional features available to the connection.
+	Features Feature
+	// FormatError rewrites errors before they are sent to the client, it is optional.
+	FormatError func(err *Error) *Error
+}
+
+// Supports reports whether or not the protocol supports all of the given features.
+func (p *Protocol) Supports(features Feature) bool {
+	return p.Features&features == features
+}
+
+var (
+	// ProtocolV1 is the original json dialect of wsrpc.
+	// It is used for connections which do not negotiate a subprotocol.
+	// Push events and client calls are left out as older clients can't handle them, clients upgrade to them by negotiating ProtocolV2JSON.
+	ProtocolV1 = &Protocol{
+		Name:     "wsrpc.v1+json",
+		Version:  1,
+		Codec:    JSONCodec,
+		Features: FeatureCancel | FeatureBidiStreams | FeatureFlowControl | FeatureResume,
+	}
+	// ProtocolV2JSON adds server initiated messages to the json dialect.
+	ProtocolV2JSON = &Protocol{
+		Name:     "wsrpc.v2+json",
+		Version:  2,
+		Codec:    JSONCodec,
+		Features: FeatureCancel | FeaturePush | FeatureClientCalls | FeatureBidiStreams | FeatureFlowControl | FeatureResume,
+	}
+	// ProtocolV2MessagePack is the MessagePack equivalent of ProtocolV2JSON.
+	ProtocolV2MessagePack = &Protocol{
+		Name:     "wsrpc.v2+msgpack",
+		Version:  2,
+		Codec:    MessagePackCodec,
+		Features: FeatureCancel | FeaturePush | FeatureClientCalls | FeatureBidiStreams | FeatureFlowControl | FeatureResume,
+	}
+)
+
+// SetProtocol registers a protocol which web socket clients can negotiate.
+// Protocols are preferred in the order they are registered, registering a protocol with a known name replaces it.
+func (r *Router) SetProtocol(protocol *Protocol) {
+	for i := range r.protocols {
+		if r.protocols[i].Name == protocol.Name {
+			r.protocols[i] = protocol
+			return
+		}
+	}
+
+	r.protocols = append(r.protocols, protocol)
+	r.wsUpgrade.Subprotocols = append(r.wsUpgrade.Subprotocols, protocol.Name)
+}
+
+// protocolFor returns the registered protocol with the given name,
+// or the default protocol if no subprotocol was negotiated.
+func (r *Router) protocolFor(name string) *Protocol {
+	for _, protocol := range r.protocols {
+		if protocol.Name == name {
+			return protocol
+		}
+	}
+
+	return ProtocolV1
+}
+
+// formatErrors applies the error format of the protocol to the responses in msg.
+func (p *Protocol) formatErrors(msg interface{}) {
+	if p.FormatError == nil {
+		return
+	}
+
+	switch m := msg.(type) {
+	case *Response:
+		if m.Error != nil {
+			m.Error = p.FormatError(m.Error)
+		}
+	case []*Response:
+		for _, res := range m {
+			p.formatErrors(res)
+		}
+	case []interface{}:
+		for _, msg := range m {
+			p.formatErrors(msg)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package wsrpc
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// retryAfterHeader is the response header through which a rate limited client is told how many milliseconds to wait before retrying.
+const retryAfterHeader = "retryAfter"
+
+var (
+	// ErrRateLimited is returned by the RateLimit middleware for jobs exceeding their rate
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// RateKey extracts the key whose rate a job counts towards, e.g. its connection or the identity of its client.
+type RateKey func(ctx Context) string
+
+// ByConn counts jobs towards the rate of the connection they were requested through.
+// It only limits web socket clients, over HTTP, i.e. long poll, REST, server-sent events and NDJSON, every request is a connection of its own.
+// Use ByClient to limit those as well.
+func ByConn(ctx Context) string {
+	conn := ctx.Conn()
+	if conn == nil {
+		return ""
+	}
+
+	return conn.Id().String()
+}
+
+// ByClient counts jobs towards the rate of their client, across all of its requests whatever the transport.
+// The client is its session if sessions are enabled, else its web socket connection, else its IP address.
+func ByClient(ctx Context) string {
+	if session := ctx.Session(); session != nil {
+		return "session:" + session.Id()
+	}
+
+	if conn := ctx.Conn(); conn != nil && conn.sock.conn != nil {
+		return "conn:" + conn.Id().String()
+	}
+
+	return "ip:" + ByRemoteIP(ctx)
+}
+
+// ByRemoteIP counts jobs towards the rate of the IP address of their client.
+func ByRemoteIP(ctx Context) string {
+	req := ctx.HttpRequest()
+	if req == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// ByMethod counts jobs towards the rate of their method.
+func ByMethod(ctx Context) string {
+	return ctx.Request().Method
+}
+
+// RateLimit returns middleware allowing limit jobs per period for every key, in bursts of up to limit jobs.
+// Jobs exceeding the rate are answered with a rate limited error, and the number of milliseconds until the next job is allowed as retryAfter header.
+// Every call returns middleware with rates of its own, so that methods can be given tighter limits than the router.
+// It panics if limit or per is not positive.
+func RateLimit(limit int, per time.Duration, key RateKey) Middleware {
+	if limit < 1 || per <= 0 {
+		panic("wsrpc: RateLimit needs a positive limit and period")
+	}
+
+	limiter := &rateLimiter{
+		limit:   float64(limit),
+		per:     per,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	return func(c Context, next NextFunc) error {
+		retryAfter, ok := limiter.take(key(c), time.Now())
+		if !ok {
+			return rateLimited{retryAfter: retryAfter}
+		}
+
+		return next(c)
+	}
+}
+
+// rateLimited is the error of a job exceeding its rate.
+type rateLimited struct {
+	retryAfter time.Duration
+}
+
+func (e rateLimited) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e rateLimited) Unwrap() error {
+	return ErrRateLimited
+}
+
+// rateLimitedResponse turns rsp into the answer to a job exceeding its rate if err is the result of it.
+func rateLimitedResponse(rsp *Response, err error) {
+	var limited rateLimited
+	if !errors.As(err, &limited) {
+		return
+	}
+
+	rsp.Error = RateLimitedError()
+	rsp.Header.Set(retryAfterHeader, int64(math.Ceil(float64(limited.retryAfter)/float64(time.Millisecond))))
+}
+
+// tokenBucket holds the tokens of a key as of updated.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per key, every bucket holds up to limit tokens and is refilled at limit tokens per period.
+type rateLimiter struct {
+	mutex   sync.Mutex
+	limit   float64
+	per     time.Duration
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// take takes a token from the bucket of key, if there is none it returns how long it takes for the next token to be added.
+func (l *rateLimiter) take(key string, now time.Time) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.limit, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.limit * float64(l.per)), false
+	}
+	bucket.tokens--
+
+	return 0, true
+}
+
+// refill returns the tokens of bucket as of now.
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updated).Seconds()/l.per.Seconds()*l.limit
+
+	return math.Min(tokens, l.limit)
+}
+
+// sweep drops the buckets which have been refilled completely once per period, they are no different from new buckets.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.per {
+		return
+	}
+	l.swept = now
+
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.limit {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package wsrpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_take(t *testing.T) {
+	start := time.Now()
+	limiter := &rateLimiter{
+		limit:   2,
+		per:     time.Second,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	tt := []struct {
+		name       string
+		key        string
+		at         time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{name: "burst", key: "a", at: 0, allowed: true},
+		{name: "burst", key: "a", at: 0, allowed: true},
+		{name: "exhausted", key: "a", at: 0, allowed: false, retryAfter: 500 * time.Millisecond},
+		{name: "other key", key: "b", at: 0, allowed: true},
+		{name: "partially refilled", key: "a", at: 250 * time.Millisecond, allowed: false, retryAfter: 250 * time.Millisecond},
+		{name: "refilled", key: "a", at: 500 * time.Millisecond, allowed: true},
+		{name: "exhausted again", key: "a", at: 500 * time.Millisecond, allowed: false, retryAfter: 500 * time.Millisecond},
+		{name: "refilled completely", key: "a", at: 5 * time.Second, allowed: true},
+		{name: "burst after refill", key: "a", at: 5 * time.Second, allowed: true},
+		{name: "exhausted after refill", key: "a", at: 5 * time.Second, allowed: false, retryAfter: 500 * time.Millisecond},
+	}
+
+	for _, tc := range tt {
+		retryAfter, allowed := limiter.take(tc.key, start.Add(tc.at))
+		if allowed != tc.allowed || retryAfter != tc.retryAfter {
+			t.Fatalf("%s: expected allowed %v retry after %v; got %v %v", tc.name, tc.allowed, tc.retryAfter, allowed, retryAfter)
+		}
+	}
+
+	if _, exists := limiter.buckets["b"]; exists {
+		t.Fatalf("expected refilled buckets to be swept")
+	}
+}
+
+func TestRateLimit_invalid(t *testing.T) {
+	tt := []struct {
+		name  string
+		limit int
+		per   time.Duration
+	}{
+		{name: "zero limit", limit: 0, per: time.Second},
+		{name: "negative limit", limit: -1, per: time.Second},
+		{name: "zero period", limit: 1, per: 0},
+	}
+
+	for _, tc := range tt {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: expected RateLimit to panic", tc.name)
+				}
+			}()
+
+			RateLimit(tc.limit, tc.per, ByMethod)
+		}()
+	}
+}
@@ -0,0 +1,229 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SetREST enables a plain HTTP facade for the handlers registered with SetHandler under the given path prefix, e.g. /rpc/.
+// POST {prefix}{method} calls the method with the body as params, GET {prefix}{method} with the query parameters as params.
+// An empty prefix disables the facade.
+func (r *Router) SetREST(prefix string) {
+	r.restPrefix = prefix
+}
+
+// servesREST reports whether or not req is addressed to the HTTP facade.
+func (r *Router) servesREST(req *http.Request) bool {
+	return r.restPrefix != "" && strings.HasPrefix(req.URL.Path, r.restPrefix)
+}
+
+// startREST calls the method named by the path of the HTTP request and answers with its result as body.
+// Params and results are always json.
+func (r *Router) startREST(sock *socket) error {
+	sock.codec = JSONCodec
+
+	req := newRequest()
+	req.Type = TypeCall
+	req.JobId = uuid.New()
+	req.Method = strings.TrimPrefix(sock.req.URL.Path, r.restPrefix)
+
+	for key, values := range sock.req.Header {
+		req.Header.Set(restHeaderKey(key), headerValue(values))
+	}
+
+	var err error
+	switch sock.req.Method {
+	case http.MethodPost:
+		req.Params, err = ioutil.ReadAll(sock.req.Body)
+		if err != nil {
+			return err
+		}
+		if len(req.Params) == 0 {
+			req.Params = nil
+		}
+		if req.Params != nil && !json.Valid(req.Params) {
+			return r.writeREST(sock, &Response{Error: ParseError()})
+		}
+
+	case http.MethodGet:
+		req.Params, err = queryParams(sock.req)
+		if err != nil {
+			return err
+		}
+
+	default:
+		sock.w.Header().Set("Allow", "GET, POST")
+		http.Error(sock.w, errMethodNotFound.Error(), http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	// The call passes through the same limits and checks as requests of any other transport, whichever dialect the router speaks
+	call := &batch{}
+	call.start(sock.ctx, []Request{req}, sock.req)
+	r.prepareBatch(sock, call)
+	defer call.kill(nil)
+
+	sock.addBatch(call)
+
+	routed := make(chan struct{})
+	go func() {
+		r.routeRequest(call, sock.channel)
+		close(routed)
+	}()
+
+	select {
+	case msg := <-sock.channel.ch:
+		res, ok := msg.(*Response)
+		if !ok {
+			return ErrChanClosed
+		}
+
+		return r.writeREST(sock, res)
+	case <-routed:
+		return errNoResponse
+	}
+}
+
+// writeREST answers the HTTP request with the result of res as body,
+// or with its error and a status code matching the error.
+func (r *Router) writeREST(sock *socket, res *Response) error {
+	for key, value := range res.Header {
+		header, err := httpHeaderValue(value)
+		if err != nil {
+			return err
+		}
+
+		sock.w.Header().Set(key, header)
+	}
+
+	body := []byte(res.Result)
+	status := http.StatusOK
+	if res.Error != nil {
+		sock.protocol.formatErrors(res)
+
+		var err error
+		body, err = json.Marshal(res.Error)
+		if err != nil {
+			return err
+		}
+		status = httpStatus(res.Error)
+	}
+
+	if len(body) == 0 {
+		sock.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	sock.w.Header().Set("Content-Type", JSONCodec.ContentType())
+	sock.w.WriteHeader(status)
+	_, err := sock.w.Write(body)
+	if err != nil {
+		r.report(r.errPreProc(err))
+	}
+
+	return nil
+}
+
+// httpStatus returns the HTTP status code corresponding to err.
+func httpStatus(err *Error) int {
+	switch err.Code {
+	case -32700, -32600, -32602:
+		return http.StatusBadRequest
+	case -32601:
+		return http.StatusNotFound
+	case codeCancelled:
+		// The job was cancelled before it could be done, e.g. as its client left
+		return http.StatusServiceUnavailable
+	case codeTimeout:
+		return http.StatusGatewayTimeout
+	case codeLimit:
+		return http.StatusRequestEntityTooLarge
+	case codeRateLimited:
+		return http.StatusTooManyRequests
+	case codeShutdown:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpHeaderValue returns a wsrpc header value as the value of an HTTP header.
+// Strings are kept as they are and other values are json, the reverse of headerValue.
+func httpHeaderValue(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// restHeaderKey returns the name of the request header carrying the HTTP header key.
+// HTTP header names are case-insensitive and arrive in canonical form, the headers interpreted by wsrpc itself are matched in any case.
+func restHeaderKey(key string) string {
+	for _, reserved := range []string{timeoutHeader, deadlineHeader} {
+		if strings.EqualFold(key, reserved) {
+			return reserved
+		}
+	}
+
+	return key
+}
+
+// headerValue returns the values of an HTTP header as a wsrpc header value.
+// Values which are valid json are decoded, other values are strings and repeated headers are arrays.
+func headerValue(values []string) interface{} {
+	decoded := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		var v interface{}
+		err := json.Unmarshal([]byte(value), &v)
+		if err != nil {
+			v = value
+		}
+
+		decoded = append(decoded, v)
+	}
+
+	if len(decoded) == 1 {
+		return decoded[0]
+	}
+
+	return decoded
+}
+
+// queryParams turns the query parameters of req into a json object.
+// Values which are valid json are kept as such, other values are strings and repeated parameters are arrays.
+func queryParams(req *http.Request) (json.RawMessage, error) {
+	query := req.URL.Query()
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	params := make(map[string]interface{}, len(query))
+	for key, values := range query {
+		decoded := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			if json.Valid([]byte(value)) {
+				decoded = append(decoded, json.RawMessage(value))
+				continue
+			}
+
+			decoded = append(decoded, value)
+		}
+
+		params[key] = decoded
+		if len(decoded) == 1 {
+			params[key] = decoded[0]
+		}
+	}
+
+	return json.Marshal(params)
+}
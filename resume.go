@@ -0,0 +1,418 @@
+package wsrpc
+
+import (
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultReplaySize is the number of responses buffered per stream if stream resumption is not configured,
+// but streams are kept running anyway, i.e. for sessions and long poll streams.
+const defaultReplaySize = 64
+
+// resumeTokenHeader is the response header through which the first response of a resumable stream carries the token needed to resume it.
+const resumeTokenHeader = "resumeToken"
+
+// resumption keeps the streams of a batch running while the client is disconnected
+// and buffers their latest responses, so that the client can resume them on a new connection.
+type resumption struct {
+	mutex    sync.Mutex
+	batch    *batch
+	size     int
+	grace    time.Duration
+	forget   func()
+	token    string
+	codec    Codec
+	session  *Session
+	sock     *socket
+	attached map[uuid.UUID]bool
+	buffers  map[uuid.UUID][]*Response
+	seqs     map[uuid.UUID]uint64
+	sent     map[uuid.UUID]uint64
+	timer    *time.Timer
+	updated  chan struct{}
+	polls    int
+	finished bool
+}
+
+// SetStreamResumption makes the streams of web socket clients resumable.
+// Up to size of the latest responses are buffered per stream, and streams are kept running for the grace period after their connection is lost.
+// A size of zero disables resumption.
+func (r *Router) SetStreamResumption(size int, grace time.Duration) {
+	r.resumeSize = size
+	r.resumeGrace = grace
+}
+
+// resumable detaches the streams of batch from the connection of sock and registers them for resumption.
+// Jobs whose JobId is already registered by another stream are answered with an error rather than registered.
+// It returns nil if the batch could not be made resumable.
+func (r *Router) resumable(sock *socket, batch *batch) *resumption {
+	if !batch.stop() {
+		// The connection is already gone
+		return nil
+	}
+
+	res := &resumption{
+		batch:    batch,
+		size:     r.resumeSize,
+		grace:    r.resumeGrace,
+		token:    uuid.New().String(),
+		codec:    sock.codec,
+		session:  sock.session,
+		sock:     sock,
+		attached: make(map[uuid.UUID]bool),
+		buffers:  make(map[uuid.UUID][]*Response),
+		seqs:     make(map[uuid.UUID]uint64),
+		sent:     make(map[uuid.UUID]uint64),
+		updated:  make(chan struct{}),
+	}
+	if res.size == 0 {
+		res.size = defaultReplaySize
+	}
+	res.forget = func() {
+		if res.session != nil {
+			res.session.removeStream(res)
+		}
+
+		r.streamsMutex.Lock()
+		defer r.streamsMutex.Unlock()
+
+		for i := range batch.jobs {
+			if r.streams[batch.jobs[i].request.JobId] == res {
+				delete(r.streams, batch.jobs[i].request.JobId)
+			}
+		}
+	}
+
+	r.streamsMutex.Lock()
+	defer r.streamsMutex.Unlock()
+
+	batch.resumption = res
+	for i := range batch.jobs {
+		job := &batch.jobs[i]
+		res.attached[job.request.JobId] = true
+		job.resumption = res
+
+		if other, exists := r.streams[job.request.JobId]; exists && other != res {
+			if job.invalid == nil {
+				job.invalid = InvalidRequestError("jobId is already in use")
+			}
+			continue
+		}
+		r.streams[job.request.JobId] = res
+	}
+
+	if res.session != nil {
+		res.session.addStream(res)
+	}
+
+	return res
+}
+
+// resume attaches the stream named by req to sock, replaying the responses sent after the seq carried as params by req.
+func (r *Router) resume(sock *socket, req *Request) *Error {
+	res, seq, rspErr := r.resumed(sock, req)
+	if rspErr != nil {
+		return rspErr
+	}
+
+	return res.resume(sock, req.JobId, seq)
+}
+
+// resumed returns the stream named by the RESUME request req along with the seq it carries as params.
+// A stream can be resumed from within its session, or by presenting the token carried by its first response.
+func (r *Router) resumed(sock *socket, req *Request) (*resumption, uint64, *Error) {
+	var seq uint64
+	if len(req.Params) > 0 {
+		err := sock.codec.Unmarshal(req.Params, &seq)
+		if err != nil {
+			return nil, 0, InvalidRequestError("seq must be a non-negative integer")
+		}
+	}
+
+	res, rspErr := r.ownedStream(sock, req)
+	if rspErr != nil {
+		return nil, 0, rspErr
+	}
+
+	return res, seq, nil
+}
+
+// ownedStream returns the stream named by req, a request addressing a detached stream, if it is owned by the client of sock.
+func (r *Router) ownedStream(sock *socket, req *Request) (*resumption, *Error) {
+	r.streamsMutex.Lock()
+	res, exists := r.streams[req.JobId]
+	r.streamsMutex.Unlock()
+
+	// Streams which are not owned by the client are reported as not found, so as to not disclose their existence
+	if !exists || !res.owned(sock, req) {
+		return nil, JobNotFoundError(req.JobId)
+	}
+
+	// Buffered responses carry raw results in the codec the streams were started with
+	if res.codec != sock.codec {
+		return nil, InvalidRequestError("streams must be addressed with the codec they were started with")
+	}
+
+	return res, nil
+}
+
+// owned reports whether or not req, received on sock, is made by the client which started the streams.
+func (r *resumption) owned(sock *socket, req *Request) bool {
+	if r.session != nil && r.session == sock.session {
+		return true
+	}
+
+	token, ok := req.Header.Get(resumeTokenHeader).String()
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) == 1
+}
+
+// write buffers res and passes it on to the client if its stream is attached.
+// The first response of each stream carries the token needed to resume it.
+func (r *resumption) write(res *Response) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if res.Seq == 1 {
+		if res.Header == nil {
+			res.Header = NewHeader()
+		}
+		res.Header.Set(resumeTokenHeader, r.token)
+	}
+
+	buffer := append(r.buffers[res.JobId], res)
+	if len(buffer) > r.size {
+		buffer[0] = nil
+		buffer = buffer[1:]
+	}
+	r.buffers[res.JobId] = buffer
+	r.seqs[res.JobId] = res.Seq
+
+	close(r.updated)
+	r.updated = make(chan struct{})
+
+	if r.sock == nil || !r.attached[res.JobId] {
+		return
+	}
+
+	err := r.sock.channel.write(res)
+	if err != nil {
+		r.detachLocked(r.sock)
+		return
+	}
+	r.sent[res.JobId] = res.Seq
+}
+
+// detach starts the grace period of the streams if they are attached to sock.
+func (r *resumption) detach(sock *socket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.detachLocked(sock)
+}
+
+func (r *resumption) detachLocked(sock *socket) {
+	if r.sock != sock || sock == nil {
+		return
+	}
+
+	r.sock = nil
+	r.attached = make(map[uuid.UUID]bool)
+
+	r.startGrace()
+}
+
+// startGrace ends the streams unless they are resumed within the grace period.
+// Streams of a session are kept until the session expires.
+func (r *resumption) startGrace() {
+	r.stopGrace()
+	if r.session != nil {
+		return
+	}
+
+	r.timer = time.AfterFunc(r.grace, func() {
+		r.batch.kill(ErrClientDisconnected)
+		r.forget()
+	})
+}
+
+func (r *resumption) stopGrace() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// resume attaches the stream of the given job to sock and replays its buffered responses after seq.
+func (r *resumption) resume(sock *socket, jobId uuid.UUID, seq uint64) *Error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.resumeLocked(sock, jobId, seq)
+}
+
+// reattach attaches all streams to sock, replaying the responses which have not been sent to the client before.
+// Streams which can not be replayed without gaps are answered with an error instead.
+func (r *resumption) reattach(sock *socket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Connections of another codec can still resume the streams once they are back on the codec of the streams
+	if r.sock != nil || r.codec != sock.codec {
+		return
+	}
+
+	for i := range r.batch.jobs {
+		jobId := r.batch.jobs[i].request.JobId
+
+		rspErr := r.resumeLocked(sock, jobId, r.sent[jobId])
+		if rspErr != nil {
+			_ = sock.channel.write(newResponse(r.batch.jobs[i].request.Id, jobId, rspErr))
+		}
+	}
+}
+
+func (r *resumption) resumeLocked(sock *socket, jobId uuid.UUID, seq uint64) *Error {
+	// The oldest response which can still be replayed
+	next := r.seqs[jobId] + 1
+	buffer := r.buffers[jobId]
+	if len(buffer) > 0 {
+		next = buffer[0].Seq
+	}
+	if next > seq+1 {
+		return ReplayGapError(seq)
+	}
+
+	r.stopGrace()
+
+	if r.sock != sock {
+		r.sock = sock
+		r.attached = make(map[uuid.UUID]bool)
+		// Streams which have ended are only resumed to receive their last responses, they can no longer be addressed
+		if !r.finished {
+			sock.addBatch(r.batch)
+		}
+	}
+
+	for _, res := range buffer {
+		if res.Seq <= seq {
+			continue
+		}
+
+		err := sock.channel.write(res)
+		if err != nil {
+			return nil
+		}
+		r.sent[jobId] = res.Seq
+	}
+	r.attached[jobId] = true
+
+	// Other streams of the batch may still have to be resumed to receive their last responses
+	if r.finished && r.delivered() {
+		r.forget()
+	}
+
+	return nil
+}
+
+// finish is called once all streams of the batch have ended.
+// Streams which ended while detached remain resumable until the grace period has passed, so that the client can receive their last responses.
+func (r *resumption) finish() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.finished = true
+	if r.sock != nil {
+		r.sock.removeBatch(r.batch)
+	}
+	if r.sock != nil && r.delivered() {
+		r.forget()
+	}
+}
+
+// poll waits up to wait for responses of the streams after the given seqs, and returns all of them.
+// The streams are kept running for the grace period after the poll.
+func (r *resumption) poll(seqs map[uuid.UUID]uint64, wait time.Duration, done <-chan struct{}) ([]*Response, *Error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	r.mutex.Lock()
+	r.polls++
+	r.stopGrace()
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.polls--
+		if r.polls == 0 && r.sock == nil {
+			r.startGrace()
+		}
+	}()
+
+	for {
+		r.mutex.Lock()
+		var responses []*Response
+		for jobId, seq := range seqs {
+			next := r.seqs[jobId] + 1
+			buffer := r.buffers[jobId]
+			if len(buffer) > 0 {
+				next = buffer[0].Seq
+			}
+			if next > seq+1 {
+				r.mutex.Unlock()
+				return nil, ReplayGapError(seq)
+			}
+
+			for _, res := range buffer {
+				if res.Seq > seq {
+					responses = append(responses, res)
+					r.sent[jobId] = res.Seq
+				}
+			}
+		}
+		if r.finished && r.delivered() {
+			r.forget()
+		}
+		updated := r.updated
+		r.mutex.Unlock()
+
+		if len(responses) > 0 {
+			return responses, nil
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			return nil, nil
+		case <-done:
+			return nil, nil
+		}
+	}
+}
+
+// delivered reports whether or not all responses have been sent to the client.
+func (r *resumption) delivered() bool {
+	for jobId, seq := range r.seqs {
+		if r.sent[jobId] < seq {
+			return false
+		}
+	}
+
+	return true
+}
+
+// conn returns the connection the streams are currently attached to, if any.
+func (r *resumption) conn() *Conn {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.sock == nil {
+		return nil
+	}
+
+	return r.sock.handle
+}
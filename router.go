@@ -1,25 +1,62 @@
 package wsrpc
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 // Router is used to demux incoming RPCs to the appropriate handlers.
 type Router struct {
-	errc         chan error
+	errMutex     sync.Mutex
 	errPreProc   func(error) error
 	errPostProc  func(error)
 	wsUpgrade    websocket.Upgrader
 	middleware   []Middleware
 	rpcFunctions map[string]functionBundle
 	rpcStreams   map[string]streamBundle
+	jsonrpc      bool
+	codecs       map[string]Codec
+	protocols    []*Protocol
+	conns        map[uuid.UUID]*Conn
+	connsMutex   sync.RWMutex
+	onConnect    func(conn *Conn)
+	onDisconnect func(conn *Conn)
+	resumeSize   int
+	resumeGrace  time.Duration
+	streams      map[uuid.UUID]*resumption
+	streamsMutex sync.Mutex
+	restPrefix   string
+	batchMode    BatchMode
+	timeouts     timeouts
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	limits       Limits
+
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	draining    atomic.Bool
+	running     atomic.Int64
+	streaming   map[*batch]bool
+	streamingMu sync.Mutex
+	server      *http.Server
+	serverMutex sync.Mutex
+
+	pollWait  time.Duration
+	pollGrace time.Duration
+
+	sessionGrace  time.Duration
+	sessions      map[string]*Session
+	sessionsMutex sync.Mutex
 }
 
 // CallHandler is used to register a handler for RPCs which require exactly one response.
@@ -28,9 +65,31 @@ type CallHandler func(ctx Context) (err error)
 // StreamHandler is sued to register handlers which need to be able to send an unknown number of responses for any given request.
 type StreamHandler func(ctx Context, ch *ResponseChannel) (err error)
 
+// BidiStreamHandler is used to register stream handlers which also receive the follow-up messages the requester sends to the stream.
+type BidiStreamHandler func(ctx Context, in *RequestChannel, out *ResponseChannel) (err error)
+
+// BatchMode decides how the results of call batches are passed back to the requester.
+type BatchMode uint
+
+const (
+	// BatchIncremental passes on the result of each job as soon as it is done, rather than one array once the whole batch is done.
+	// Long poll requests are always answered with one array.
+	BatchIncremental BatchMode = 1 << iota
+	// BatchOrdered passes on the results in request order rather than completion order,
+	// jobs which end without a result are given a placeholder with an error.
+	BatchOrdered
+)
+
 type bundle struct {
-	method     string
-	middleware []Middleware
+	method       string
+	middleware   []Middleware
+	group        *Group
+	registration *Registration
+}
+
+// Registration is returned when registering a method, it sets the options of the method.
+type Registration struct {
+	timeouts *timeouts
 }
 
 type functionBundle struct {
@@ -41,25 +100,43 @@ type functionBundle struct {
 type streamBundle struct {
 	bundle
 	stream StreamHandler
+	bidi   BidiStreamHandler
 }
 
 // Returns a new router with default settings
 func NewRouter() *Router {
-	return &Router{
+	router := &Router{
 		wsUpgrade: websocket.Upgrader{
 			HandshakeTimeout: 10 * time.Second,
 		},
 		errPreProc:   func(err error) error { return err },
 		errPostProc:  func(err error) { fmt.Println("Router err: ", err) },
-		errc:         make(chan error),
 		rpcFunctions: make(map[string]functionBundle),
 		rpcStreams:   make(map[string]streamBundle),
+		conns:        make(map[uuid.UUID]*Conn),
+		streams:      make(map[uuid.UUID]*resumption),
+		streaming:    make(map[*batch]bool),
+		sessions:     make(map[string]*Session),
+		codecs: map[string]Codec{
+			JSONCodec.Name():        JSONCodec,
+			MessagePackCodec.Name(): MessagePackCodec,
+		},
 	}
+
+	router.ctx, router.cancel = context.WithCancelCause(context.Background())
+
+	router.SetProtocol(ProtocolV2MessagePack)
+	router.SetProtocol(ProtocolV2JSON)
+	router.SetProtocol(ProtocolV1)
+
+	return router
 }
 
 type Config struct {
 	// Specifies the allowed origins that can initiate a websocket connection
 	Origins []string
+	// Limits caps the resources a single client can claim
+	Limits Limits
 }
 
 // NewRouterFromConfig returns a new router from a custom Config.
@@ -76,6 +153,7 @@ func NewRouterFromConfig(cfg *Config) *Router {
 
 		return false
 	}
+	router.SetLimits(cfg.Limits)
 
 	return router
 }
@@ -83,60 +161,166 @@ func NewRouterFromConfig(cfg *Config) *Router {
 // ServeHTTP is responsible for interpreting incoming HTTP requests and if appropriate upgrade the connection to web sockets.
 // In either case it attempts to run the requested handler func.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.rejectShutdown(w) {
+		return
+	}
+
 	sock := newSocket(w, req)
-	defer sock.kill()
+	defer sock.kill(ErrClientDisconnected)
+	defer context.AfterFunc(r.ctx, func() {
+		sock.kill(context.Cause(r.ctx))
+	})()
+
+	sock.limiter = r.newJobLimiter()
+	r.limitBody(sock)
 
 	var err error
+	sock.codec, err = r.codecFor(req)
+	if err != nil {
+		r.report(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.jsonrpc {
+		sock.protocol = ProtocolJSONRPC
+		sock.codec = JSONCodec
+	}
+
+	var header http.Header
+	if r.sessionGrace > 0 {
+		header = r.joinSession(sock)
+		defer r.leaveSession(sock)
+	}
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+
+	if r.servesREST(req) {
+		err = r.startREST(sock)
+		if err != nil {
+			r.report(err)
+
+			httpError(w, err, http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if acceptsNDJSON(req) {
+		err = r.startNDJSON(sock)
+		if err != nil {
+			r.report(err)
+
+			httpError(w, err, http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	if acceptsEventStream(req) {
+		err = r.startSSE(sock)
+		if err != nil {
+			r.report(err)
+
+			httpError(w, err, http.StatusBadRequest)
+		}
+
+		return
+	}
+
 	switch req.Method {
 	case http.MethodPost:
 		err = r.startLongPoll(sock)
 		if err != nil {
-			r.errc <- err
+			r.report(err)
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpError(w, err, http.StatusInternalServerError)
 		}
 
 	case http.MethodGet:
-		sock.conn, err = r.wsUpgrade.Upgrade(w, req, nil)
+		sock.conn, err = r.wsUpgrade.Upgrade(w, req, header)
 		if err != nil {
-			r.errc <- err
+			r.report(err)
 
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if sock.conn.Subprotocol() != "" {
+			sock.protocol = r.protocolFor(sock.conn.Subprotocol())
+			sock.codec = sock.protocol.Codec
+		}
+
+		if r.jsonrpc {
+			sock.protocol = ProtocolJSONRPC
+			sock.codec = JSONCodec
+		}
+
 		err = r.startWS(sock)
 		if err != nil {
-			r.errc <- err
+			r.report(err)
 
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 
 	default:
-		r.errc <- errMethodNotFound
+		r.report(errMethodNotFound)
 		http.Error(w, errMethodNotFound.Error(), http.StatusBadRequest)
 	}
 
 }
 
 // Start is used to start a web server on the supplied address.
+// Once the router is shut down http.ErrServerClosed is returned.
 func (r *Router) Start(address string) error {
-	defer close(r.errc)
+	server := &http.Server{Addr: address, Handler: r}
 
-	go func() {
-		for {
-			err, ok := <-r.errc
-			if !ok {
-				return
-			}
+	return r.serve(server, server.ListenAndServe)
+}
 
-			if r.errPostProc != nil {
-				r.errPostProc(err)
-			}
-		}
-	}()
+// StartTLS is used to start a web server serving HTTPS on the supplied address, with the certificate and matching private key in the supplied files.
+// Once the router is shut down http.ErrServerClosed is returned.
+func (r *Router) StartTLS(address, certFile, keyFile string) error {
+	server := &http.Server{Addr: address, Handler: r}
 
-	return http.ListenAndServe(address, r)
+	return r.serve(server, func() error {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// Serve is used to start a web server accepting connections on the supplied listener, e.g. one set up with TLS or on a unix socket.
+// Once the router is shut down http.ErrServerClosed is returned.
+func (r *Router) Serve(listener net.Listener) error {
+	server := &http.Server{Handler: r}
+
+	return r.serve(server, func() error {
+		return server.Serve(listener)
+	})
+}
+
+// serve runs server, which is shut down along with the router.
+// The router doesn't need to be started to be served, it can be mounted in any server as it is an http.Handler.
+func (r *Router) serve(server *http.Server, run func() error) error {
+	r.serverMutex.Lock()
+	if r.draining.Load() {
+		r.serverMutex.Unlock()
+		return http.ErrServerClosed
+	}
+	r.server = server
+	r.serverMutex.Unlock()
+
+	return run()
+}
+
+// report passes err on to the error post processor, one error at a time.
+func (r *Router) report(err error) {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+
+	if r.errPostProc != nil {
+		r.errPostProc(err)
+	}
 }
 
 // Use applies middleware to router
@@ -156,41 +340,122 @@ func (r *Router) SetErrorPostProc(fn func(error)) {
 }
 
 // SetHandler registers a call handler func.
-func (r *Router) SetHandler(method string, handler CallHandler, middleware ...Middleware) {
+func (r *Router) SetHandler(method string, handler CallHandler, middleware ...Middleware) *Registration {
 	r.rpcFunctions[method] = functionBundle{
 		bundle: bundle{
-			method:     method,
-			middleware: middleware,
+			method:       method,
+			middleware:   middleware,
+			registration: &Registration{},
 		},
 		function: handler,
 	}
+
+	return r.rpcFunctions[method].registration
 }
 
 // SetStream registers a stream handler func.
-func (r *Router) SetStream(method string, handler StreamHandler, middleware ...Middleware) {
+func (r *Router) SetStream(method string, handler StreamHandler, middleware ...Middleware) *Registration {
 	r.rpcStreams[method] = streamBundle{
 		bundle: bundle{
-			method:     method,
-			middleware: middleware,
+			method:       method,
+			middleware:   middleware,
+			registration: &Registration{},
 		},
 		stream: handler,
 	}
+
+	return r.rpcStreams[method].registration
+}
+
+// SetBidiStream registers a bidirectional stream handler func.
+func (r *Router) SetBidiStream(method string, handler BidiStreamHandler, middleware ...Middleware) *Registration {
+	r.rpcStreams[method] = streamBundle{
+		bundle: bundle{
+			method:       method,
+			middleware:   middleware,
+			registration: &Registration{},
+		},
+		bidi: handler,
+	}
+
+	return r.rpcStreams[method].registration
+}
+
+// registration returns the registration of the method requested by req, or nil if there is no such method.
+func (r *Router) registration(req *Request) *Registration {
+	switch req.Type {
+	case TypeStream:
+		if rh, exists := r.rpcStreams[req.Method]; exists {
+			return rh.registration
+		}
+	case TypeCall, TypeNotify:
+		if rh, exists := r.rpcFunctions[req.Method]; exists {
+			return rh.registration
+		}
+	}
+
+	return nil
+}
+
+// SetBatchMode decides how the results of call batches are passed back, the modes can be combined.
+// By default results are passed back as one array in completion order.
+func (r *Router) SetBatchMode(mode BatchMode) {
+	r.batchMode = mode
+}
+
+// applyBatchMode applies the batch mode of the router to batch, incremental results are only applied if the transport allows it.
+func (r *Router) applyBatchMode(batch *batch, incremental bool) {
+	batch.isOrdered = r.batchMode&BatchOrdered != 0
+	if incremental && r.batchMode&BatchIncremental != 0 && !batch.isJSONRPC {
+		batch.isIncremental = true
+	}
 }
 
 func (r *Router) startWS(sock *socket) error {
 	defer func() {
 		err := sock.conn.Close()
 		if err != nil {
-			r.errc <- err
+			r.report(err)
 		}
 	}()
 
-	go r.sendOutput(sock.conn, sock.channel)
+	go r.sendOutput(sock, sock.channel)
+
+	defer context.AfterFunc(r.ctx, func() {
+		r.goAway(sock)
+	})()
+
+	r.connect(sock.handle)
+	defer r.disconnect(sock.handle)
+
+	if sock.protocol.Supports(FeatureResume) {
+		r.reattach(sock)
+	}
+
+	if r.pingInterval > 0 {
+		r.startHeartbeat(sock)
+	}
+
+	if r.limits.MaxMessageSize > 0 {
+		sock.conn.SetReadLimit(r.limits.MaxMessageSize)
+	}
 
 	var errCount int64
 	for {
 		t, data, err := sock.conn.ReadMessage()
 		if err != nil {
+			if isTimeout(err) {
+				sock.kill(ErrPeerUnresponsive)
+				return ErrPeerUnresponsive
+			}
+			if context.Cause(sock.ctx) == ErrServerShutdown {
+				// The client has been told that the server is going away
+				return nil
+			}
+			if err == websocket.ErrReadLimit {
+				// The client has been sent a close message telling it why
+				return err
+			}
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, 4000) {
 				return err
 			}
@@ -209,100 +474,405 @@ func (r *Router) startWS(sock *socket) error {
 			continue
 		}
 		errCount = 0
+		r.extendReadDeadline(sock)
+
+		if t != sock.codec.FrameType() {
+			r.report(r.errPreProc(errUnsupportedFrame))
+			continue
+		}
+
+		if sock.handle.resolve(data) {
+			continue
+		}
+
+		batch, err := r.createBatch(sock, data)
+		if err != nil {
+			r.report(r.errPreProc(err))
+
+			if rsp := r.rejection(err); rsp != nil {
+				err = sock.channel.write(rsp)
+				if err != nil {
+					r.report(r.errPreProc(err))
+				}
+			}
+
+			continue
+		}
+
+		if batch.isControl {
+			r.controlJobs(sock, batch)
+			// Control requests address other jobs, their own contexts are done with once handled
+			batch.kill(nil)
+			continue
+		}
+
+		r.applyBatchMode(batch, true)
+
+		if sock.protocol.Supports(FeatureFlowControl) {
+			batch.startFlowControl()
+		}
 
-		if t != websocket.TextMessage {
-			r.errc <- r.errPreProc(errUnsupportedFrame)
+		if batch.isStream && (r.resumeSize > 0 || sock.session != nil) && sock.protocol.Supports(FeatureResume) {
+			r.resumable(sock, batch)
+		}
+		if !batch.isStream && sock.session != nil && sock.protocol.Supports(FeatureResume) && !r.keep(sock, batch) {
+			// The connection is already gone
+			batch.kill(ErrClientDisconnected)
 			continue
 		}
 
-		batch, err := createBatch(data, sock.req)
+		sock.addBatch(batch)
+
+		go func() {
+			r.routeRequest(batch, sock.channel)
+			sock.removeBatch(batch)
+		}()
+	}
+}
+
+// createBatch decodes data into a batch of jobs in the dialect spoken by the router, subject to the limits of the router.
+func (r *Router) createBatch(sock *socket, data []byte) (*batch, error) {
+	create := createBatch
+	if r.jsonrpc {
+		create = createJSONRPCBatch
+	}
+
+	batch, err := create(sock.ctx, sock.codec, data, sock.req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.prepareBatch(sock, batch)
+
+	return batch, nil
+}
+
+// prepareBatch subjects batch, created for sock, to the limits and timeouts of the router.
+func (r *Router) prepareBatch(sock *socket, batch *batch) {
+	batch.limiter = sock.limiter
+	r.limitBatch(batch)
+	r.applyTimeouts(batch)
+
+	// Jobs can still be addressed during a shutdown, but no new ones are started
+	if r.draining.Load() && !batch.isControl {
+		for i := range batch.jobs {
+			batch.jobs[i].invalid = ShutdownError()
+		}
+	}
+}
+
+// rejection returns the message sent to the client when its message could not be turned into a batch,
+// or nil if the client should not be answered.
+func (r *Router) rejection(err error) interface{} {
+	rpcErr, ok := err.(*Error)
+	if !r.jsonrpc || !ok {
+		return nil
+	}
+
+	return newJSONRPCResponse(nil, &Response{Error: rpcErr})
+}
+
+// controlJobs applies the requests of a control batch to the running jobs they name.
+// Cancelled jobs acknowledge the cancellation themselves once their handlers wind down and messages are not answered,
+// requests which can not be applied are answered right away.
+func (r *Router) controlJobs(sock *socket, batch *batch) {
+	for _, job := range batch.jobs {
+		rspErr := job.invalid
+		if rspErr == nil {
+			rspErr = r.controlJob(sock, job.request)
+		}
+		if rspErr == nil {
+			continue
+		}
+
+		resp := job.NewResponse()
+		resp.Error = rspErr
+
+		err := sock.channel.write(resp)
 		if err != nil {
-			r.errc <- r.errPreProc(err)
+			r.report(r.errPreProc(err))
+		}
+	}
+}
+
+func (r *Router) controlJob(sock *socket, req *Request) *Error {
+	switch req.Type {
+	case TypeCancel:
+		if !sock.protocol.Supports(FeatureCancel) {
+			return TypeNotFoundError(req.Type)
+		}
+	case TypeMessage, TypeClose:
+		if !sock.protocol.Supports(FeatureBidiStreams) {
+			return TypeNotFoundError(req.Type)
+		}
+	case TypeCredit:
+		if !sock.protocol.Supports(FeatureFlowControl) {
+			return TypeNotFoundError(req.Type)
+		}
+	case TypeResume:
+		if !sock.protocol.Supports(FeatureResume) {
+			return TypeNotFoundError(req.Type)
 		}
 
-		sock.batches = append(sock.batches, batch)
+		// Resumed streams are not yet known to the connection
+		return r.resume(sock, req)
+	}
+
+	job := sock.job(req.JobId)
+	if job == nil || job.Err() != nil {
+		return JobNotFoundError(req.JobId)
+	}
+
+	switch req.Type {
+	case TypeCancel:
+		job.kill(ErrJobCancelled)
+		return nil
+	case TypeCredit:
+		return grantCredits(sock.codec, job, req)
+	}
+
+	rh, exists := r.rpcStreams[job.request.Method]
+	if !exists || rh.bidi == nil || job.inbound == nil {
+		return InvalidRequestError("job does not accept messages")
+	}
+
+	if req.Type == TypeClose {
+		job.inbound.Close()
+		return nil
+	}
 
-		go r.routeRequest(batch, sock.channel)
+	err := job.inbound.write(req)
+	if err != nil {
+		return InvalidRequestError("job does not accept messages")
 	}
+
+	return nil
+}
+
+// grantCredits grants the stream of job the number of credits carried as params by req, which are decoded with codec.
+func grantCredits(codec Codec, job *job, req *Request) *Error {
+	if job.flow == nil {
+		return InvalidRequestError("job is not under flow control")
+	}
+
+	var credits int64
+	err := codec.Unmarshal(req.Params, &credits)
+	if err != nil || credits < 1 {
+		return InvalidRequestError("credits must be a positive integer")
+	}
+
+	job.flow.grant(credits)
+
+	return nil
 }
 
 func (r *Router) startLongPoll(sock *socket) error {
-	defer sock.kill()
+	defer sock.kill(ErrLongPollAnswered)
 
 	data, err := ioutil.ReadAll(sock.req.Body)
 	if err != nil {
 		return err
 	}
 
-	batch, err := createBatch(data, sock.req)
+	if len(data) == 0 && sock.session != nil {
+		return r.collectLongPoll(sock)
+	}
+
+	batch, err := r.createBatch(sock, data)
 	if err != nil {
-		r.errc <- r.errPreProc(err)
-		return err
+		r.report(r.errPreProc(err))
+
+		rsp := r.rejection(err)
+		if rsp == nil {
+			return err
+		}
+
+		return r.writeLongPoll(sock, rsp)
 	}
-	defer batch.kill()
 
-	sock.batches = append(sock.batches, batch)
+	if r.longPollStreams(sock, batch) {
+		if batch.isStream {
+			return r.startLongPollStream(sock, batch)
+		}
+		defer batch.kill(ErrLongPollAnswered)
 
-	go r.routeRequest(batch, sock.channel)
+		return r.pollLongPollStream(sock, batch)
+	}
+	defer batch.kill(ErrLongPollAnswered)
 
-	msg, err := sock.channel.read()
-	if err != nil {
-		return err
+	r.applyBatchMode(batch, false)
+
+	sock.addBatch(batch)
+
+	routed := make(chan struct{})
+	go func() {
+		r.routeRequest(batch, sock.channel)
+		close(routed)
+	}()
+
+	var msg interface{}
+	select {
+	case msg = <-sock.channel.ch:
+	case <-routed:
+		// The batch was routed without output, e.g. a batch of notifications
+		sock.w.WriteHeader(http.StatusNoContent)
+		return nil
 	}
-	batch.kill()
+	if msg == nil {
+		return ErrChanClosed
+	}
+	batch.kill(ErrLongPollAnswered)
 
-	data, err = json.Marshal(msg)
+	return r.writeLongPoll(sock, msg)
+}
+
+// writeLongPoll writes msg as the response to a long poll request.
+func (r *Router) writeLongPoll(sock *socket, msg interface{}) error {
+	sock.protocol.formatErrors(msg)
+
+	data, err := r.marshalOutput(sock, msg)
 	if err != nil {
 		return err
 	}
 
+	sock.w.Header().Set("Content-Type", sock.codec.ContentType())
 	sock.w.WriteHeader(http.StatusOK)
 	_, err = sock.w.Write(data)
 	if err != nil {
-		r.errc <- r.errPreProc(err)
+		r.report(r.errPreProc(err))
 	}
 
 	return nil
 }
 
+// flushOutput routes batch and passes every message it outputs to write, flushing the response after each of them.
+// It returns once the batch has been routed, the client is gone or write fails.
+func (r *Router) flushOutput(sock *socket, batch *batch, flusher http.Flusher, write func(msg interface{}) error) {
+	routed := make(chan struct{})
+	go func() {
+		r.routeRequest(batch, sock.channel)
+		close(routed)
+	}()
+
+	for {
+		var msg interface{}
+		select {
+		case msg = <-sock.channel.ch:
+		case <-routed:
+			return
+		case <-sock.ctx.Done():
+			return
+		}
+		if msg == nil {
+			return
+		}
+
+		sock.protocol.formatErrors(msg)
+
+		err := write(msg)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
 func (r *Router) routeRequest(batch *batch, outc *InfChannel) {
-	defer batch.kill()
+	defer batch.kill(nil)
+
+	// Streams are tracked so that they can be ended on shutdown
+	if batch.isStream {
+		r.streamingMu.Lock()
+		r.streaming[batch] = true
+		r.streamingMu.Unlock()
+
+		defer func() {
+			r.streamingMu.Lock()
+			delete(r.streaming, batch)
+			r.streamingMu.Unlock()
+		}()
+	}
 
 	batchc := batch.channel
 
+	// Jobs without a handler only ever produce their error response
+	unrouted := make(map[uuid.UUID]bool)
+
 	for i := range batch.jobs {
 		job := batch.jobs[i]
 
 		handler, err := r.createHandler(job, batchc)
+		if err == nil {
+			err = batch.limiter.acquire(job.request)
+		}
 		if err != nil {
-			r.errc <- err
+			r.report(err)
+			unrouted[job.request.JobId] = true
 
 			resp := job.NewResponse()
 			resp.Error = err
 
 			err := batchc.Write(resp)
 			if err != nil {
-				r.errc <- err
+				r.report(err)
 			}
 
 			continue
 		}
 
+		// Streams are ended rather than waited for on shutdown, only calls count as running
+		running := job.request.Type != TypeStream
+		if running {
+			r.running.Add(1)
+		}
 		go func() {
+			if running {
+				defer r.running.Add(-1)
+			}
+			// The job is answered once it times out, but its slot is only released once the handler returns
+			defer batch.limiter.release(job.request)
+			defer r.answerTimeout(job, batchc)()
+
 			err := handler()
 			if err != nil {
+				// Notifications are not answered, their errors are propagated instead
+				if job.request.notification {
+					r.report(r.errPreProc(err))
+				}
+
+				// Jobs which timed out are answered with the timeout instead
+				if job.timedOut() {
+					return
+				}
+
 				resp := job.NewResponse()
 				resp.Error = ServerError(err)
+				rateLimitedResponse(resp, err)
+
+				if job.cancelled() {
+					// Streams have already acknowledged the cancellation
+					if job.request.Type == TypeStream {
+						return
+					}
+
+					resp.Error = Cancelled()
+				}
 
-				err = batchc.Write(resp)
+				err = job.answer(batchc, resp)
 				if err != nil {
-					r.errc <- r.errPreProc(err)
+					r.report(r.errPreProc(err))
 				}
 			}
 		}()
 	}
 
 	if batch.isStream {
+		if batch.resumption != nil {
+			defer batch.resumption.finish()
+		}
+
+		seqs := make(map[uuid.UUID]uint64, len(batch.jobs))
+		done := make(map[uuid.UUID]bool, len(batch.jobs))
 		runningJobs := len(batch.jobs)
 		for runningJobs > 0 {
 			res, err := batchc.read()
@@ -314,10 +884,35 @@ func (r *Router) routeRequest(batch *batch, outc *InfChannel) {
 				continue
 			}
 
-			if res.Error != nil && res.Error.Code == 205 {
-				batch.killJob(res.JobId)
+			// Responses of jobs which have ended are dropped, e.g. those of a handler which keeps writing after its job timed out
+			if done[res.JobId] {
+				continue
+			}
+
+			// Streams end with EOF, their cancellation or an error ending them early, calls with their only response
+			job := batch.job(res.JobId)
+			ended := res.Error != nil && (res.Error.Code == codeEOF || res.Error.Code == codeCancelled || res.Error.Code == codeTimeout || res.Error.Code == codeRateLimited || res.Error.Code == codeShutdown)
+			if job != nil && (job.request.Type != TypeStream || unrouted[res.JobId]) {
+				ended = true
+			}
+			if ended {
+				done[res.JobId] = true
+				batch.killJob(res.JobId, nil)
 				runningJobs--
 			}
+
+			if job != nil && job.request.notification {
+				continue
+			}
+
+			seqs[res.JobId]++
+			res.Seq = seqs[res.JobId]
+
+			if batch.resumption != nil {
+				batch.resumption.write(res)
+				continue
+			}
+
 			err = outc.write(res)
 			// Output channel is closed pleas cancel all..
 			if err != nil {
@@ -328,17 +923,30 @@ func (r *Router) routeRequest(batch *batch, outc *InfChannel) {
 		return
 	}
 
+	if batch.isIncremental {
+		r.collectResults(batch, func(res *Response) error {
+			var msg interface{} = res
+			if batch.isJSONRPC {
+				msg = batch.jsonrpcResponses([]*Response{res})[0]
+			}
+
+			return output(batch, outc, msg)
+		})
+
+		return
+	}
+
 	result := make([]*Response, 0, len(batch.jobs))
-	for range batch.jobs {
-		res, err := batchc.read()
-		if err != nil {
-			continue
-		}
+	r.collectResults(batch, func(res *Response) error {
 		result = append(result, res)
-	}
+		return nil
+	})
 
 	if len(result) == 0 {
-		r.errc <- r.errPreProc(errors.New("either batch has no jobs or all batch jobs channels are closed"))
+		if !batch.hasNotifications() {
+			r.report(r.errPreProc(errors.New("either batch has no jobs or all batch jobs channels are closed")))
+		}
+
 		return
 	}
 
@@ -347,13 +955,87 @@ func (r *Router) routeRequest(batch *batch, outc *InfChannel) {
 		res = result[0]
 	}
 
-	err := outc.write(res)
+	if batch.isJSONRPC {
+		rsps := batch.jsonrpcResponses(result)
+
+		res = rsps
+		if !batch.isSlice {
+			res = rsps[0]
+		}
+	}
+
+	err := output(batch, outc, res)
 	if err != nil {
-		r.errc <- r.errPreProc(err)
+		r.report(r.errPreProc(err))
+	}
+}
+
+// collectResults reads the results of the jobs of a call batch and passes them on to emit, notifications are left out.
+// Results are passed on in the order they complete, or in request order if the batch is ordered,
+// in which case jobs which end without a result are given a placeholder with an error.
+func (r *Router) collectResults(batch *batch, emit func(res *Response) error) {
+	results := make([]*Response, len(batch.jobs))
+	next := 0
+
+	// flush passes on the results which are next in request order
+	flush := func() error {
+		for ; next < len(batch.jobs); next++ {
+			if batch.jobs[next].request.notification {
+				continue
+			}
+			if results[next] == nil {
+				return nil
+			}
+
+			err := emit(results[next])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for range batch.jobs {
+		res, err := batch.channel.read()
+		if err != nil {
+			continue
+		}
+
+		i := batch.jobIndex(res.JobId)
+		if i >= 0 && batch.jobs[i].request.notification {
+			continue
+		}
+
+		if !batch.isOrdered || i < 0 {
+			err = emit(res)
+		} else {
+			results[i] = res
+			err = flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	if !batch.isOrdered {
+		return
 	}
+
+	for i := range batch.jobs {
+		if results[i] == nil {
+			results[i] = batch.jobs[i].placeholder()
+		}
+	}
+
+	_ = flush()
 }
 
 func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *Error) {
+	if job.invalid != nil {
+		return nil, job.invalid
+	}
+
 	var handler func() error
 
 	switch job.request.Type {
@@ -367,6 +1049,18 @@ func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *E
 			defer func() {
 				select {
 				case <-cc.Done():
+					if !job.cancelled() {
+						return
+					}
+
+					rsp := cc.NewResponse()
+					rsp.Error = Cancelled()
+
+					err := job.answer(jobc, rsp)
+					if err != nil {
+						r.report(fmt.Errorf("exec handler could not write cancel resp: %v", err))
+					}
+
 					return
 				default:
 				}
@@ -374,32 +1068,31 @@ func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *E
 				if cc.Response().Result != nil || (cc.Response().Header != nil && len(cc.Response().Header) > 0) {
 					err := jobc.Write(cc.Response())
 					if err != nil {
-						r.errc <- fmt.Errorf("exec handler could not send respose: %v", err)
+						r.report(fmt.Errorf("exec handler could not send respose: %v", err))
 					}
 				}
 
 				rsp := cc.NewResponse()
 				rsp.Error = EOF()
 
-				err := jobc.Write(rsp)
+				err := job.answer(jobc, rsp)
 				if err != nil {
-					r.errc <- fmt.Errorf("exec handler could not write error resp: %v", err)
+					r.report(fmt.Errorf("exec handler could not write error resp: %v", err))
 				}
 			}()
 
-			err := rh.stream(cc, jobc)
-			if err != nil {
-				return err
+			if rh.bidi != nil {
+				return rh.bidi(cc, job.inbound, jobc)
 			}
 
-			return nil
+			return rh.stream(cc, jobc)
 		}
 
 		handler = func() error {
-			return processMiddleware(job, exec, append(r.middleware, rh.middleware...)...)
+			return processMiddleware(job, exec, r.chain(rh.bundle)...)
 		}
 
-	case TypeCall:
+	case TypeCall, TypeNotify:
 		rh, exists := r.rpcFunctions[job.request.Method]
 		if !exists {
 			return nil, MethodNotFoundError(job.request.Method)
@@ -412,7 +1105,12 @@ func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *E
 				return err
 			}
 
-			err = jobc.Write(job.response)
+			// Jobs which timed out are answered with the timeout instead
+			if job.timedOut() {
+				return nil
+			}
+
+			err = job.answer(jobc, job.response)
 			if err != nil {
 				return err
 			}
@@ -421,7 +1119,7 @@ func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *E
 		}
 
 		handler = func() error {
-			return processMiddleware(job, exec, append(r.middleware, rh.middleware...)...)
+			return processMiddleware(job, exec, r.chain(rh.bundle)...)
 		}
 
 	default: // Type not found
@@ -431,23 +1129,77 @@ func (r *Router) createHandler(job job, jobc *ResponseChannel) (func() error, *E
 	return handler, nil
 }
 
-func (r *Router) sendOutput(conn *websocket.Conn, outputc *InfChannel) {
+func (r *Router) sendOutput(sock *socket, outputc *InfChannel) {
 	for {
 		res, err := outputc.read()
 		if err != nil {
 			return
 		}
 
-		data, err := json.Marshal(res)
+		sock.protocol.formatErrors(res)
+
+		data, err := r.marshalOutput(sock, res)
 		if err != nil {
-			r.errc <- err
+			r.report(err)
 
 			continue
 		}
 
-		err = conn.WriteMessage(websocket.TextMessage, data)
+		err = sock.conn.WriteMessage(sock.codec.FrameType(), data)
 		if err != nil {
-			r.errc <- err
+			r.report(err)
+		}
+	}
+}
+
+// marshalOutput encodes msg, output to sock, with the codec of sock.
+// Responses which can not be encoded, e.g. as their result is not a valid value of the codec, are answered with a server error
+// rather than dropped, so that the client is not left waiting for them.
+func (r *Router) marshalOutput(sock *socket, msg interface{}) ([]byte, error) {
+	data, err := sock.codec.Marshal(msg)
+	if err == nil {
+		return data, nil
+	}
+
+	data, rspErr := sock.codec.Marshal(unencodable(sock, msg))
+	if rspErr != nil {
+		return nil, err
+	}
+	r.report(err)
+
+	return data, nil
+}
+
+// unencodable replaces the responses within msg which can not be encoded with the codec of sock by server errors.
+func unencodable(sock *socket, msg interface{}) interface{} {
+	switch m := msg.(type) {
+	case *Response:
+		_, err := sock.codec.Marshal(m)
+		if err == nil {
+			return m
+		}
+
+		rsp := *m
+		rsp.Result = nil
+		rsp.Error = ServerError(err)
+		sock.protocol.formatErrors(&rsp)
+
+		return &rsp
+	case []*Response:
+		responses := make([]*Response, len(m))
+		for i := range m {
+			responses[i] = unencodable(sock, m[i]).(*Response)
+		}
+
+		return responses
+	case []interface{}:
+		msgs := make([]interface{}, len(m))
+		for i := range m {
+			msgs[i] = unencodable(sock, m[i])
 		}
+
+		return msgs
 	}
+
+	return msg
 }
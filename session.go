@@ -0,0 +1,433 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modfin/kv"
+)
+
+const (
+	// SessionHeader is the HTTP header through which the session id is issued to and presented by clients.
+	SessionHeader = "Wsrpc-Session"
+	// SessionCookie is the cookie through which the session id is issued to and presented by clients.
+	SessionCookie = "wsrpc_session"
+)
+
+var (
+	// ErrSessionExpired is the cause of contexts cancelled because the session was not rejoined within its grace period
+	ErrSessionExpired = errors.New("session expired")
+)
+
+type sessionKey struct{}
+
+// Session spans all connections a client makes with the same session id, web socket and long poll alike.
+// It keeps its state, running streams and calls alive for a grace period after the last connection is gone.
+type Session struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mutex   sync.Mutex
+	values  map[string]interface{}
+	conns   int
+	timer   *time.Timer
+	expired bool
+	streams []*resumption
+	calls   []*batch
+	socks   []*socket
+	held    []heldOutput
+}
+
+// heldOutput is output of a call which was done after its connection was gone, held for the next connection of the session.
+type heldOutput struct {
+	codec Codec
+	msg   interface{}
+}
+
+func newSession() *Session {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &Session{
+		id:     uuid.New().String(),
+		ctx:    ctx,
+		cancel: cancel,
+		values: make(map[string]interface{}),
+	}
+}
+
+// Id returns the unique id of the session.
+func (s *Session) Id() string {
+	return s.id
+}
+
+// Context returns the session context, it is cancelled when the session expires.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Set puts a key value pair on the session.
+func (s *Session) Set(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[key] = value
+}
+
+// Get collects a key value pair from the session.
+func (s *Session) Get(key string) *kv.KV {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, exists := s.values[key]
+	if !exists {
+		return kv.New("", nil)
+	}
+
+	return kv.New(key, value)
+}
+
+// Delete removes a key value pair from the session.
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.values, key)
+}
+
+// join adds a connection to the session, it reports false if the session has already expired.
+func (s *Session) join() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.expired {
+		return false
+	}
+
+	s.conns++
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	return true
+}
+
+// leave removes a connection from the session and starts the grace period once the last connection is gone.
+func (s *Session) leave(grace time.Duration, forget func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conns--
+	if s.conns > 0 {
+		return
+	}
+
+	s.timer = time.AfterFunc(grace, func() {
+		s.expire(forget)
+	})
+}
+
+// expire ends the session and its streams unless a connection has rejoined it in the meantime.
+func (s *Session) expire(forget func()) {
+	s.mutex.Lock()
+	if s.conns > 0 || s.expired {
+		s.mutex.Unlock()
+		return
+	}
+	s.expired = true
+	streams := s.streams
+	s.streams = nil
+	s.held = nil
+	s.mutex.Unlock()
+
+	forget()
+	s.cancel(ErrSessionExpired)
+	for _, res := range streams {
+		res.batch.kill(ErrSessionExpired)
+		res.forget()
+	}
+}
+
+func (s *Session) addStream(res *resumption) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.streams = append(s.streams, res)
+}
+
+func (s *Session) removeStream(res *resumption) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.streams {
+		if s.streams[i] == res {
+			s.streams = append(s.streams[:i], s.streams[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeCall removes a call which is done from the session, and from the connections it was added to on attach.
+func (s *Session) removeCall(batch *batch) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sock := range s.socks {
+		sock.removeBatch(batch)
+	}
+
+	for i := range s.calls {
+		if s.calls[i] == batch {
+			s.calls = append(s.calls[:i], s.calls[i+1:]...)
+			return
+		}
+	}
+}
+
+// attach makes sock receive the output of calls whose connection is gone, starting with the output held so far.
+// The running calls of the session are added to sock, so that they can be addressed by its client.
+// Output is only passed on to connections of the codec the call was made with, as results are raw values of it.
+func (s *Session) attach(sock *socket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.socks = append(s.socks, sock)
+	sock.addBatch(s.calls...)
+
+	held := s.held[:0]
+	for _, out := range s.held {
+		if out.codec != sock.codec || sock.channel.write(out.msg) != nil {
+			held = append(held, out)
+		}
+	}
+	s.held = held
+}
+
+// detach stops passing output on to sock.
+func (s *Session) detach(sock *socket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.socks {
+		if s.socks[i] == sock {
+			s.socks = append(s.socks[:i], s.socks[i+1:]...)
+			return
+		}
+	}
+}
+
+// deliver writes the output msg of a call kept by the session to outc, the output of the connection the call was made on.
+// If that connection is gone msg is passed on to another connection of the session, or held for the next one.
+func (s *Session) deliver(outc *InfChannel, codec Codec, msg interface{}) {
+	if outc.write(msg) == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.expired {
+		return
+	}
+
+	for _, sock := range s.socks {
+		if sock.codec == codec && sock.channel.write(msg) == nil {
+			return
+		}
+	}
+
+	s.held = append(s.held, heldOutput{codec: codec, msg: msg})
+}
+
+// takeHeld removes and returns the output held for connections of codec.
+func (s *Session) takeHeld(codec Codec) []interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var msgs []interface{}
+	held := s.held[:0]
+	for _, out := range s.held {
+		if out.codec != codec {
+			held = append(held, out)
+			continue
+		}
+		msgs = append(msgs, out.msg)
+	}
+	s.held = held
+
+	return msgs
+}
+
+// detachedStreams returns the streams of the session which are not attached to any connection.
+func (s *Session) detachedStreams() []*resumption {
+	s.mutex.Lock()
+	streams := append([]*resumption(nil), s.streams...)
+	s.mutex.Unlock()
+
+	detached := streams[:0]
+	for _, res := range streams {
+		if res.conn() == nil {
+			detached = append(detached, res)
+		}
+	}
+
+	return detached
+}
+
+// SetSessions enables sessions which span reconnects, they are kept for the grace period after their last connection is gone.
+// A grace period of zero disables sessions.
+func (r *Router) SetSessions(grace time.Duration) {
+	r.sessionGrace = grace
+}
+
+// joinSession adds sock to the session presented by its client, or to a new session if the client presents no known session.
+// It returns the HTTP headers issuing the session id to the client.
+func (r *Router) joinSession(sock *socket) http.Header {
+	id := sock.req.Header.Get(SessionHeader)
+	if cookie, err := sock.req.Cookie(SessionCookie); id == "" && err == nil {
+		id = cookie.Value
+	}
+
+	r.sessionsMutex.Lock()
+	session, exists := r.sessions[id]
+	if !exists || !session.join() {
+		session = newSession()
+		session.join()
+		r.sessions[session.id] = session
+	}
+	r.sessionsMutex.Unlock()
+
+	sock.session = session
+	sock.ctx = context.WithValue(sock.ctx, sessionKey{}, session)
+
+	header := http.Header{}
+	header.Set(SessionHeader, session.id)
+	cookie := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    session.id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   sock.req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	header.Add("Set-Cookie", cookie.String())
+
+	return header
+}
+
+// leaveSession removes sock from its session.
+func (r *Router) leaveSession(sock *socket) {
+	session := sock.session
+	session.detach(sock)
+	session.leave(r.sessionGrace, func() {
+		r.sessionsMutex.Lock()
+		defer r.sessionsMutex.Unlock()
+
+		delete(r.sessions, session.id)
+	})
+}
+
+// reattach attaches the detached streams and the calls of the session of sock to sock.
+func (r *Router) reattach(sock *socket) {
+	if sock.session == nil {
+		return
+	}
+
+	for _, res := range sock.session.detachedStreams() {
+		res.reattach(sock)
+	}
+	sock.session.attach(sock)
+}
+
+// keep keeps the call batch running when the connection of sock is gone, until it is routed or the session of sock expires.
+// It reports false if the connection is already gone.
+func (r *Router) keep(sock *socket, batch *batch) bool {
+	if !batch.stop() {
+		return false
+	}
+
+	session := sock.session
+	stopSession := context.AfterFunc(session.ctx, func() {
+		batch.kill(context.Cause(session.ctx))
+	})
+	stopRouter := context.AfterFunc(r.ctx, func() {
+		batch.kill(context.Cause(r.ctx))
+	})
+
+	batch.session = session
+	batch.codec = sock.codec
+	batch.stop = func() bool {
+		session.removeCall(batch)
+
+		stopped := stopSession()
+		return stopRouter() && stopped
+	}
+
+	session.mutex.Lock()
+	session.calls = append(session.calls, batch)
+	session.mutex.Unlock()
+
+	return true
+}
+
+// output writes msg, output of batch, to outc. The output of calls kept by a session is delivered through the session.
+func output(batch *batch, outc *InfChannel, msg interface{}) error {
+	if batch.session == nil {
+		return outc.write(msg)
+	}
+
+	batch.session.deliver(outc, batch.codec, msg)
+
+	return nil
+}
+
+// followSession attaches the detached streams and the calls of the session of sock to sock,
+// and passes their output on to write until the client is gone.
+// It lets the client of a session carry on over server-sent events or newline delimited json after its connection is lost.
+func (r *Router) followSession(sock *socket, flusher http.Flusher, write func(msg interface{}) error) {
+	// Output is read for as long as sock is alive, so that the streams and calls of the session are never blocked by the client
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+
+		for {
+			msg, err := sock.channel.read()
+			if err != nil {
+				return
+			}
+
+			sock.protocol.formatErrors(msg)
+
+			err = write(msg)
+			if err != nil {
+				r.report(r.errPreProc(err))
+				continue
+			}
+			flusher.Flush()
+		}
+	}()
+
+	r.reattach(sock)
+	<-sock.ctx.Done()
+
+	// The response can only be written to until the request is served
+	sock.kill(context.Cause(sock.ctx))
+	<-sent
+}
+
+// collectLongPoll answers a long poll request without a body with the output held by its session,
+// or with 204 No Content if there is none.
+func (r *Router) collectLongPoll(sock *socket) error {
+	msgs := sock.session.takeHeld(sock.codec)
+	if len(msgs) == 0 {
+		sock.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return r.writeLongPoll(sock, msgs)
+}
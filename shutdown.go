@@ -0,0 +1,133 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// drainInterval is how often Shutdown checks whether the running calls have finished.
+const drainInterval = 10 * time.Millisecond
+
+var (
+	// ErrServerShutdown is the cause of contexts cancelled because the router has been shut down
+	ErrServerShutdown = errors.New("server shutting down")
+)
+
+// Shutdown gracefully shuts down the router.
+// It stops accepting connections and jobs and ends the running streams with a shutdown error, code 503, and ErrServerShutdown as cause.
+// The running calls are then left to finish until ctx is done, after which the remaining ones are cancelled with ErrServerShutdown as cause.
+// Web socket clients are finally sent a close message with the going away close code, telling them to reconnect elsewhere.
+// If the router was started with Start, StartTLS or Serve the server is shut down as well, and the error of shutting it down is returned.
+func (r *Router) Shutdown(ctx context.Context) error {
+	r.draining.Store(true)
+
+	// Streams run until they are told to stop, which would hold up the server and its requests serving them
+	r.endStreams(ErrServerShutdown)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		r.serverMutex.Lock()
+		server := r.server
+		r.serverMutex.Unlock()
+
+		if server == nil {
+			serverErr <- nil
+			return
+		}
+
+		err := server.Shutdown(ctx)
+		if err != nil {
+			// Requests which are still active are cut
+			_ = server.Close()
+		}
+		serverErr <- err
+	}()
+
+	r.drain(ctx)
+
+	r.streamsMutex.Lock()
+	streams := make([]*resumption, 0, len(r.streams))
+	for _, res := range r.streams {
+		streams = append(streams, res)
+	}
+	r.streamsMutex.Unlock()
+
+	for _, res := range streams {
+		res.batch.kill(ErrServerShutdown)
+		res.forget()
+	}
+
+	r.cancel(ErrServerShutdown)
+
+	return <-serverErr
+}
+
+// endStreams cancels the stream jobs of all running batches with cause and answers them with the shutdown error.
+func (r *Router) endStreams(cause error) {
+	r.streamingMu.Lock()
+	batches := make([]*batch, 0, len(r.streaming))
+	for batch := range r.streaming {
+		batches = append(batches, batch)
+	}
+	r.streamingMu.Unlock()
+
+	for _, batch := range batches {
+		for _, job := range batch.jobs {
+			if job.request.Type != TypeStream {
+				continue
+			}
+
+			job.kill(cause)
+
+			rsp := job.NewResponse()
+			rsp.Error = ShutdownError()
+
+			// The batch may have ended in the meantime
+			_ = job.answer(batch.channel, rsp)
+		}
+	}
+}
+
+// drain waits until the router runs no calls, or until ctx is done.
+func (r *Router) drain(ctx context.Context) {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for r.running.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// goAway tells the web socket client of sock that the server is going away and closes the connection.
+func (r *Router) goAway(sock *socket) {
+	sock.kill(ErrServerShutdown)
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, ErrServerShutdown.Error())
+	err := sock.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	if err != nil {
+		r.report(r.errPreProc(err))
+	}
+
+	_ = sock.conn.Close()
+}
+
+// rejectShutdown answers the HTTP request with 503 Service Unavailable if the router is shutting down.
+// It reports whether or not the request was rejected.
+func (r *Router) rejectShutdown(w http.ResponseWriter) bool {
+	if !r.draining.Load() {
+		return false
+	}
+
+	w.Header().Set("Connection", "close")
+	http.Error(w, ErrServerShutdown.Error(), http.StatusServiceUnavailable)
+
+	return true
+}
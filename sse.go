@@ -0,0 +1,151 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const eventStreamContentType = "text/event-stream"
+
+var (
+	errEventStreamUnsupported = errors.New("server-sent events are not supported by the response writer")
+	errEventStreamNotStream   = errors.New("server-sent events are only available for streams")
+)
+
+// acceptsEventStream reports whether or not the client asks for the response as server-sent events.
+func acceptsEventStream(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType == eventStreamContentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eventStreamRequest returns the request of a server-sent events client, or nil if there is none.
+// GET requests carry the request as the query parameters method, params, header and jobId, other requests carry it as body.
+func eventStreamRequest(sock *socket) ([]byte, error) {
+	if sock.req.Method != http.MethodGet {
+		return ioutil.ReadAll(sock.req.Body)
+	}
+
+	query := sock.req.URL.Query()
+	if query.Get("method") == "" {
+		return nil, nil
+	}
+
+	req := newRequest()
+	req.Type = TypeStream
+	req.Method = query.Get("method")
+	req.JobId = uuid.New()
+
+	if jobId := query.Get("jobId"); jobId != "" {
+		var err error
+		req.JobId, err = uuid.Parse(jobId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params := query.Get("params"); params != "" {
+		req.Params = json.RawMessage(params)
+		if !json.Valid(req.Params) {
+			return nil, ParseError()
+		}
+	}
+
+	if header := query.Get("header"); header != "" {
+		err := json.Unmarshal([]byte(header), &req.Header)
+		if err != nil {
+			return nil, ParseError()
+		}
+	}
+
+	return json.Marshal(&req)
+}
+
+// startSSE runs the requested stream and sends every response as a server-sent event until the stream ends.
+// Within a session a request without a method follows the session instead, see followSession.
+// Requests and events are always json, as are the params and results of their jobs.
+func (r *Router) startSSE(sock *socket) error {
+	flusher, ok := sock.w.(http.Flusher)
+	if !ok {
+		return errEventStreamUnsupported
+	}
+	sock.codec = JSONCodec
+
+	data, err := eventStreamRequest(sock)
+	if err != nil {
+		return err
+	}
+
+	write := func(msg interface{}) error {
+		return writeEvents(sock.w, msg)
+	}
+
+	if len(data) == 0 && sock.session != nil {
+		writeEventStreamHeader(sock.w, flusher)
+		r.followSession(sock, flusher, write)
+
+		return nil
+	}
+
+	batch, err := r.createBatch(sock, data)
+	if err != nil {
+		return err
+	}
+	if !batch.isStream {
+		batch.kill(nil)
+		return errEventStreamNotStream
+	}
+
+	sock.addBatch(batch)
+
+	writeEventStreamHeader(sock.w, flusher)
+	r.flushOutput(sock, batch, flusher, write)
+
+	return nil
+}
+
+func writeEventStreamHeader(w http.ResponseWriter, flusher http.Flusher) {
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+}
+
+// writeEvents writes the responses of msg as server-sent events, other messages are left out.
+func writeEvents(w io.Writer, msg interface{}) error {
+	var responses []*Response
+	switch m := msg.(type) {
+	case *Response:
+		responses = []*Response{m}
+	case []*Response:
+		responses = m
+	}
+
+	for _, res := range responses {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		// The seq of a response is only unique within its job
+		_, err = fmt.Fprintf(w, "id: %s:%d\ndata: %s\n\n", res.JobId, res.Seq, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package wsrpc
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// timeoutHeader is the request header through which a client sets the timeout of a job, in milliseconds.
+const timeoutHeader = "timeout"
+
+// deadlineHeader is the request header through which a client sets the deadline of a job, as an RFC 3339 timestamp.
+const deadlineHeader = "deadline"
+
+// maxTimeoutHeader is the largest timeout header which can be converted to a duration, larger ones are clamped to it.
+const maxTimeoutHeader = math.MaxInt64 / int64(time.Millisecond)
+
+// timeouts bounds how long the handler of a job may run.
+type timeouts struct {
+	timeout time.Duration
+	max     time.Duration
+}
+
+// SetTimeouts sets the timeout applied to jobs whose request carries neither a timeout nor a deadline header,
+// and the maximum timeout any job may run for regardless of its request. Zero disables either.
+// Timeouts set for a method on registration take precedence, see Registration.Timeout.
+func (r *Router) SetTimeouts(timeout, max time.Duration) {
+	r.timeouts = timeouts{
+		timeout: timeout,
+		max:     max,
+	}
+}
+
+// Timeout sets the timeout and maximum timeout of the jobs of the method, overriding those of the router.
+func (reg *Registration) Timeout(timeout, max time.Duration) *Registration {
+	reg.timeouts = &timeouts{
+		timeout: timeout,
+		max:     max,
+	}
+
+	return reg
+}
+
+// applyTimeouts sets the deadline of every job of batch which is bound by a timeout.
+// Jobs whose request carries an invalid timeout or deadline header are answered with an error instead.
+func (r *Router) applyTimeouts(batch *batch) {
+	if batch.isControl {
+		return
+	}
+
+	now := time.Now()
+	for i := range batch.jobs {
+		job := &batch.jobs[i]
+
+		deadline, rspErr := r.deadline(job.request, now)
+		if rspErr != nil {
+			if job.invalid == nil {
+				job.invalid = rspErr
+			}
+			continue
+		}
+
+		if !deadline.IsZero() {
+			job.setDeadline(deadline)
+		}
+	}
+}
+
+// deadline returns the deadline of a job for req started at now, or the zero time if it is not bound by any timeout.
+// The deadline requested by the client is bounded by the maximum timeout of the method, or else the router.
+func (r *Router) deadline(req *Request, now time.Time) (time.Time, *Error) {
+	limits := r.timeouts
+	if reg := r.registration(req); reg != nil && reg.timeouts != nil {
+		limits = *reg.timeouts
+	}
+
+	deadline, rspErr := requestDeadline(req, now)
+	if rspErr != nil {
+		return time.Time{}, rspErr
+	}
+
+	if deadline.IsZero() && limits.timeout > 0 {
+		deadline = now.Add(limits.timeout)
+	}
+	if limits.max > 0 && (deadline.IsZero() || deadline.After(now.Add(limits.max))) {
+		deadline = now.Add(limits.max)
+	}
+
+	return deadline, nil
+}
+
+// requestDeadline returns the deadline carried by the timeout or deadline header of req, whichever is earlier,
+// or the zero time if there is none.
+func requestDeadline(req *Request, now time.Time) (time.Time, *Error) {
+	var deadline time.Time
+
+	if header := req.Header.Get(timeoutHeader); !header.IsNil() {
+		ms, ok := header.Int()
+		if !ok || ms < 1 {
+			return time.Time{}, InvalidRequestError("timeout must be a positive number of milliseconds")
+		}
+		if ms > maxTimeoutHeader {
+			ms = maxTimeoutHeader
+		}
+
+		deadline = now.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	if header := req.Header.Get(deadlineHeader); !header.IsNil() {
+		value, _ := header.String()
+
+		at, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, InvalidRequestError("deadline must be an RFC 3339 timestamp")
+		}
+
+		if deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+
+	return deadline, nil
+}
+
+// setDeadline bounds the context of the job by deadline, after which it is done with the cause ErrJobTimeout.
+func (j *job) setDeadline(deadline time.Time) {
+	ctx, stop := context.WithDeadlineCause(j.Context, deadline, ErrJobTimeout)
+
+	cancel := j.cancel
+	j.Context = ctx
+	j.cancel = func(cause error) {
+		cancel(cause)
+		stop()
+	}
+}
+
+// timedOut reports whether or not the job has been running past its deadline.
+func (j job) timedOut() bool {
+	return context.Cause(j.Context) == ErrJobTimeout
+}
+
+// answerTimeout answers the job with a timeout error once its deadline passes, whether or not its handler has returned,
+// so that a hung handler doesn't hold up its job. The returned func stops it.
+func (r *Router) answerTimeout(job job, jobc *ResponseChannel) func() bool {
+	return context.AfterFunc(job, func() {
+		if !job.timedOut() {
+			return
+		}
+
+		rsp := job.NewResponse()
+		rsp.Error = TimeoutError()
+
+		err := job.answer(jobc, rsp)
+		if err != nil {
+			r.report(r.errPreProc(err))
+		}
+	})
+}